@@ -109,6 +109,7 @@ func PermitAppLoginHandler(gctx *gin.Context) {
 		IdToken:      "",
 		AccessToken:  "",
 		JwtId:        "",
+		ExpireTime:   business.SessionExpireTime(),
 		Account:      sessionAccountModel.Uid,
 		Client:       sql.NullString{String: request.App, Valid: true},
 		Link:         sql.NullString{String: request.Link, Valid: true},
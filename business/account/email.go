@@ -74,10 +74,12 @@ func MailSignupBeginHandler(gctx *gin.Context) {
 		return
 	}
 
-	// TODO: 为防止被刷，暂不发送邮件
-	// subject := "注册验证码"
-	// body := "您的验证码是: " + session.Code
-	// err = email.SendMail(mailSender, subject, body, username)
+	// TODO: 为防止被刷，暂不发送邮件；启用时通过mail队列由worker发送，与通知摘要使用同一个MAIL_SMTP_URL和MAIL_SENDER
+	// _, err = jobs.EnqueueMail(gctx, &jobs.MailSendPayload{
+	// 	To:      []string{username},
+	// 	Subject: "注册验证码",
+	// 	Body:    "您的验证码是: " + session.Code,
+	// })
 	// if err != nil {
 	// 	gctx.JSON(http.StatusOK, nemodels.NECodeError.ToResult())
 	// 	return
@@ -163,10 +165,12 @@ func MailSigninBeginHandler(gctx *gin.Context) {
 		return
 	}
 
-	// TODO: 为防止被刷，暂不发送邮件
-	// subject := "登陆验证码"
-	// body := "您的验证码是: " + session.Code
-	// err = email.SendMail(mailSender, subject, body, username)
+	// TODO: 为防止被刷，暂不发送邮件；启用时通过mail队列由worker发送，与通知摘要使用同一个MAIL_SMTP_URL和MAIL_SENDER
+	// _, err = jobs.EnqueueMail(gctx, &jobs.MailSendPayload{
+	// 	To:      []string{username},
+	// 	Subject: "登陆验证码",
+	// 	Body:    "您的验证码是: " + session.Code,
+	// })
 	// if err != nil {
 	// 	gctx.JSON(http.StatusOK, nemodels.NECodeError.ToResult())
 	// 	return
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"portal/business"
 	"portal/models"
 )
 
@@ -38,6 +39,12 @@ func SessionQueryHandler(gctx *gin.Context) {
 		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在"))
 		return
 	}
+	if sessionAccountModel.Expired() {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("会话已过期"))
+		return
+	}
+	// 应用通过link持续查询授权会话，查询时同样延长有效期
+	business.RenewSessionIfNeeded(sessionAccountModel)
 	databaseAccountModel, err := models.GetAccount(sessionAccountModel.Account)
 	if err != nil || databaseAccountModel == nil {
 		logrus.Warnln("UserinfoHandler", err)
@@ -89,6 +89,7 @@ func SigninHandler(gctx *gin.Context) {
 		IdToken:      "",
 		AccessToken:  "",
 		JwtId:        "",
+		ExpireTime:   business.SessionExpireTime(),
 		Account:      accountModel.Uid,
 	}
 	if request.Link != "" {
@@ -116,6 +116,7 @@ func SignupHandler(gctx *gin.Context) {
 		IdToken:      "",
 		AccessToken:  "",
 		JwtId:        "",
+		ExpireTime:   business.SessionExpireTime(),
 		Account:      accountModel.Uid,
 	}
 	err = models.PutSession(sessionModel)
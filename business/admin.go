@@ -0,0 +1,38 @@
+package business
+
+import (
+	"portal/models"
+	"portal/services/confighelper"
+)
+
+// 判断账号是否为管理员，根用户以及ADMIN_ACCOUNTS中配置的账号视为管理员
+func IsAdminAccount(accountModel *models.AccountModel) bool {
+	if accountModel == nil || accountModel.IsAnonymous() {
+		return false
+	}
+	if accountModel.Uid == models.RootAccount.Uid {
+		return true
+	}
+	for _, uid := range confighelper.GetStringList("ADMIN_ACCOUNTS") {
+		if uid == accountModel.Uid {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断账号是否可以调用同步接口，根用户以及SYNC_ACCOUNTS中配置的账号可以写入同步的发布状态和文章内容
+func IsSyncAccount(accountModel *models.AccountModel) bool {
+	if accountModel == nil || accountModel.IsAnonymous() {
+		return false
+	}
+	if accountModel.Uid == models.RootAccount.Uid {
+		return true
+	}
+	for _, uid := range confighelper.GetStringList("SYNC_ACCOUNTS") {
+		if uid == accountModel.Uid {
+			return true
+		}
+	}
+	return false
+}
@@ -24,6 +24,9 @@ type MTNoteMatter struct {
 	Keywords    string `json:"keywords"`
 	Cover       string `json:"cover"`
 	Chan        string `json:"chan"`
+	Channel     string `json:"channel"`
+	Lang        string `json:"lang"`
+	Draft       bool   `json:"draft"` // 草稿不发布
 }
 
 type MTNoteTable struct {
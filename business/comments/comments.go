@@ -3,6 +3,7 @@ package comments
 import (
 	"fmt"
 	nemodels "github.com/pnnh/neutron/models"
+	"portal/services/markdown"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -12,6 +13,14 @@ import (
 
 const CommentViewersRedisKey = "comment:viewers"
 
+// 评论审核状态
+const (
+	CommentStatusPending  = 0 // 待审核，仅作者本人可见
+	CommentStatusApproved = 1 // 已通过，所有人可见
+	CommentStatusRejected = 2 // 已拒绝
+	CommentStatusSpam     = 3 // 垃圾评论
+)
+
 type CommentModel struct {
 	Uid         string    `json:"uid"`     // 主键标识
 	Content     string    `json:"content"` // 内容
@@ -28,28 +37,51 @@ type CommentModel struct {
 	Nickname    string    `json:"nickname"`
 	Website     string    `json:"website"`
 	Discover    int       `json:"discover"`
+	Edited      bool      `json:"edited"`                         // 作者是否编辑过
+	Deleted     bool      `json:"deleted"`                        // 作者是否已删除，删除后保留记录以维持楼层结构
+	ContentHtml string    `json:"content_html" db:"content_html"` // 服务端渲染并过滤后的HTML
+
+	Mentions    []*markdown.Mention `json:"mentions" db:"-"`     // 内容中@提及的账号
+	Reactions   map[string]int      `json:"reactions" db:"-"`    // 各类反应的数量
+	MyReactions []string            `json:"my_reactions" db:"-"` // 当前查看者自己的反应
+	Score       int                 `json:"score" db:"-"`        // 赞成数减去反对数
+}
+
+// 评论的历史版本，每次编辑或删除前记录一次
+type CommentHistoryModel struct {
+	Uid        string    `json:"uid"`
+	Comment    string    `json:"comment"`
+	Content    string    `json:"content"`
+	Action     string    `json:"action"`
+	Creator    string    `json:"creator"`
+	CreateTime time.Time `json:"create_time" db:"create_time"`
 }
 
+const (
+	CommentHistoryActionEdit   = "edit"
+	CommentHistoryActionDelete = "delete"
+)
+
 func PGInsertComment(model *CommentModel) error {
 	sqlText := `insert into comments(uid, content, create_time, update_time, creator, thread, referer, 
-        resource, ipaddress, fingerprint, email, nickname, website, status)
+        resource, ipaddress, fingerprint, email, nickname, website, status, content_html)
 values(:uid, :content, now(), now(), :creator, :thread, :referer, :resource, :ipaddress, :fingerprint, 
-       :email, :nickname, :website, 0)
-on conflict (uid)
-do update set content = excluded.content, update_time = now();`
+       :email, :nickname, :website, :status, :content_html);`
 
 	sqlParams := map[string]interface{}{
-		"uid":         model.Uid,
-		"content":     model.Content,
-		"creator":     model.Creator,
-		"thread":      model.Thread,
-		"referer":     model.Referer,
-		"resource":    model.Resource,
-		"ipaddress":   model.IPAddress,
-		"fingerprint": model.Fingerprint,
-		"email":       model.EMail,
-		"nickname":    model.Nickname,
-		"website":     model.Website,
+		"uid":          model.Uid,
+		"content":      model.Content,
+		"creator":      model.Creator,
+		"thread":       model.Thread,
+		"referer":      model.Referer,
+		"resource":     model.Resource,
+		"ipaddress":    model.IPAddress,
+		"fingerprint":  model.Fingerprint,
+		"email":        model.EMail,
+		"nickname":     model.Nickname,
+		"website":      model.Website,
+		"status":       model.Status,
+		"content_html": model.ContentHtml,
 	}
 
 	_, err := datastore.NamedExec(sqlText, sqlParams)
@@ -59,15 +91,134 @@ do update set content = excluded.content, update_time = now();`
 	return nil
 }
 
-func SelectComments(resource string, page int, size int) (*nemodels.NESelectResponse, error) {
+func PGGetComment(uid string) (*CommentModel, error) {
+	sqlText := `select * from comments where uid = :uid;`
+
+	sqlParams := map[string]interface{}{"uid": uid}
+	var sqlResults []*CommentModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	for _, item := range sqlResults {
+		return item, nil
+	}
+	return nil, nil
+}
+
+// 在同一事务中先把旧内容写入历史表，再更新评论内容
+func pgReviseComment(model *CommentModel, action string, updateSqlText string,
+	updateSqlParams map[string]interface{}) (opErr error) {
+	sqlTx, err := datastore.NewTranscation()
+	if err != nil {
+		return fmt.Errorf("pgReviseComment: %w", err)
+	}
+	defer func() {
+		if opErr != nil {
+			if err := sqlTx.Rollback(); err != nil {
+				opErr = fmt.Errorf("%w\nRollback: %v", opErr, err)
+			}
+		}
+	}()
+
+	historyText := `insert into comment_history(uid, comment, content, action, creator, create_time)
+select :uid, uid, content, :action, creator, now() from comments where uid = :comment and deleted = false;`
+	historyParams := map[string]interface{}{
+		"uid":     helpers.MustUuid(),
+		"comment": model.Uid,
+		"action":  action,
+	}
+	historyRows, err := sqlTx.NamedQuery(historyText, historyParams)
+	if err != nil {
+		return fmt.Errorf("pgReviseComment history: %w", err)
+	}
+	if err = historyRows.Close(); err != nil {
+		return fmt.Errorf("pgReviseComment history close: %w", err)
+	}
+
+	updateRows, err := sqlTx.NamedQuery(updateSqlText, updateSqlParams)
+	if err != nil {
+		return fmt.Errorf("pgReviseComment update: %w", err)
+	}
+	if err = updateRows.Close(); err != nil {
+		return fmt.Errorf("pgReviseComment update close: %w", err)
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("pgReviseComment Commit: %w", err)
+	}
+	return nil
+}
+
+// PGUpdateCommentContent 作者编辑评论，旧内容保存到comment_history
+func PGUpdateCommentContent(model *CommentModel) error {
+	sqlText := `update comments set content = :content, content_html = :content_html, edited = true, 
+        update_time = now() where uid = :uid and deleted = false;`
+	sqlParams := map[string]interface{}{
+		"uid":          model.Uid,
+		"content":      model.Content,
+		"content_html": model.ContentHtml,
+	}
+	if err := pgReviseComment(model, CommentHistoryActionEdit, sqlText, sqlParams); err != nil {
+		return fmt.Errorf("PGUpdateCommentContent: %w", err)
+	}
+	return nil
+}
+
+// PGDeleteComment 软删除评论，清空内容但保留记录，回复仍然挂在原楼层下
+func PGDeleteComment(model *CommentModel) error {
+	sqlText := `update comments set content = '', content_html = '', deleted = true, update_time = now() 
+where uid = :uid and deleted = false;`
+	sqlParams := map[string]interface{}{
+		"uid": model.Uid,
+	}
+	if err := pgReviseComment(model, CommentHistoryActionDelete, sqlText, sqlParams); err != nil {
+		return fmt.Errorf("PGDeleteComment: %w", err)
+	}
+	return nil
+}
+
+func PGSelectCommentHistory(comment string) ([]*CommentHistoryModel, error) {
+	sqlText := `select * from comment_history where comment = :comment order by create_time desc;`
+
+	sqlParams := map[string]interface{}{"comment": comment}
+	var sqlResults []*CommentHistoryModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+type CommentSelectParams struct {
+	Resource string
+	Viewer   string // 当前查看的账号，作者可以看到自己待审核的评论
+	Sort     string // 排序方式，默认按发布时间倒序，top按得分倒序
+}
+
+func SelectComments(params *CommentSelectParams, page int, size int) (*nemodels.NESelectResponse, error) {
 
 	pagination := helpers.CalcPaginationByPage(page, size)
-	baseSqlText := ` select * from comments where resource = :resource and (status = 1 or discover is null or discover <= 10) 
-                        order by create_time desc `
+	baseSqlText := ` select * from comments where resource = :resource 
+                        and (status = 1 or (status = 0 and creator = :viewer)) `
+	orderSqlText := ` order by create_time desc `
+	if params.Sort == CommentSortTop {
+		orderSqlText = ` order by ` + commentScoreSqlText + ` desc, create_time desc `
+	}
 
-	pageSqlText := baseSqlText + ` offset :offset limit :limit; `
+	pageSqlText := baseSqlText + orderSqlText + ` offset :offset limit :limit; `
 	pageSqlParams := map[string]interface{}{
-		"resource": resource,
+		"resource": params.Resource,
+		"viewer":   params.Viewer,
 		"offset":   pagination.Offset, "limit": pagination.Limit}
 	var sqlResults []*CommentModel
 
@@ -79,14 +230,32 @@ func SelectComments(resource string, page int, size int) (*nemodels.NESelectResp
 		return nil, fmt.Errorf("StructScan: %w", err)
 	}
 
+	// 只查询当前页评论的提及和反应
+	pageUids := make([]string, 0, len(sqlResults))
+	for _, item := range sqlResults {
+		pageUids = append(pageUids, item.Uid)
+	}
+	mentionMap, err := pgSelectCommentsMentions(pageUids)
+	if err != nil {
+		return nil, fmt.Errorf("pgSelectCommentsMentions: %w", err)
+	}
+
+	reactionCounts, err := pgSelectCommentsReactions(pageUids, params.Viewer)
+	if err != nil {
+		return nil, fmt.Errorf("pgSelectCommentsReactions: %w", err)
+	}
+
 	resultRange := make([]any, 0)
 	for _, item := range sqlResults {
+		ensureCommentHtml(item)
+		item.Mentions = mentionMap[item.Uid]
+		fillCommentReactions(item, reactionCounts)
 		resultRange = append(resultRange, item)
 	}
 
 	countSqlText := `select count(1) as count from (` + baseSqlText + `) as temp;`
 
-	countSqlParams := map[string]interface{}{"resource": resource}
+	countSqlParams := map[string]interface{}{"resource": params.Resource, "viewer": params.Viewer}
 	var countSqlResults []struct {
 		Count int `db:"count"`
 	}
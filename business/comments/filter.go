@@ -0,0 +1,294 @@
+package comments
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"portal/business/cloudflare"
+	"portal/models"
+	"portal/services/confighelper"
+
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/helpers"
+	"github.com/pnnh/neutron/services/datastore"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// 评论过滤器的输入，在写入数据库之前构造
+type CommentFilterInput struct {
+	Comment        *CommentModel
+	Account        *models.AccountModel
+	TurnstileToken string
+}
+
+// 评论过滤器，返回CommentRejectError表示评论被拒绝，返回其它错误表示过滤器本身执行出错
+type CommentFilter interface {
+	Name() string
+	Check(input *CommentFilterInput) error
+}
+
+type CommentRejectError struct {
+	Filter string
+	Reason string
+}
+
+func (e *CommentRejectError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Filter, e.Reason)
+}
+
+// 按顺序执行的过滤器，开销小的放在前面
+var commentFilters = []CommentFilter{
+	&keywordCommentFilter{},
+	&linkCommentFilter{},
+	&duplicateCommentFilter{},
+	&rateLimitCommentFilter{},
+	&humanVerifyCommentFilter{},
+}
+
+// 编辑评论时只检查内容本身，不再计算频率和重复
+var commentEditFilters = []CommentFilter{
+	&keywordCommentFilter{},
+	&linkCommentFilter{},
+}
+
+// 追加自定义的评论过滤器，需要在服务启动前调用
+func RegisterCommentFilter(filter CommentFilter) {
+	commentFilters = append(commentFilters, filter)
+}
+
+// 依次执行发布评论的过滤器，遇到第一个拒绝时停止并记录到comment_rejections表
+func RunCommentFilters(input *CommentFilterInput) error {
+	return runCommentFilters(commentFilters, input)
+}
+
+func RunCommentEditFilters(input *CommentFilterInput) error {
+	return runCommentFilters(commentEditFilters, input)
+}
+
+func runCommentFilters(filters []CommentFilter, input *CommentFilterInput) error {
+	for _, filter := range filters {
+		err := filter.Check(input)
+		if err == nil {
+			continue
+		}
+		var rejectErr *CommentRejectError
+		if errors.As(err, &rejectErr) {
+			if logErr := PGInsertCommentRejection(input.Comment, rejectErr); logErr != nil {
+				logrus.Warnln("RunCommentFilters", logErr)
+			}
+			return rejectErr
+		}
+		return fmt.Errorf("RunCommentFilters %s: %w", filter.Name(), err)
+	}
+	return nil
+}
+
+// 编译后的COMMENT_BLOCKLIST，keywords已转为小写
+type commentBlocklist struct {
+	keywords []string
+	patterns []*regexp.Regexp
+}
+
+var (
+	blocklistOnce sync.Once
+	blocklist     *commentBlocklist
+)
+
+// LoadCommentBlocklist 在配置加载后编译COMMENT_BLOCKLIST，有误的正则表达式在启动时输出警告并忽略
+func LoadCommentBlocklist() {
+	blocklistOnce.Do(func() {
+		blocklist = &commentBlocklist{}
+		for _, item := range confighelper.GetStringList("COMMENT_BLOCKLIST") {
+			pattern, ok := strings.CutPrefix(item, "re:")
+			if !ok {
+				blocklist.keywords = append(blocklist.keywords, strings.ToLower(item))
+				continue
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				logrus.Warnln("COMMENT_BLOCKLIST 正则表达式有误，已忽略", pattern, err)
+				continue
+			}
+			blocklist.patterns = append(blocklist.patterns, re)
+		}
+	})
+}
+
+// 关键词黑名单，COMMENT_BLOCKLIST中以re:开头的条目按正则表达式匹配，其它条目忽略大小写按子串匹配
+type keywordCommentFilter struct{}
+
+func (f *keywordCommentFilter) Name() string {
+	return "keyword"
+}
+
+func (f *keywordCommentFilter) Check(input *CommentFilterInput) error {
+	LoadCommentBlocklist()
+	lowerContent := strings.ToLower(input.Comment.Content)
+	for _, keyword := range blocklist.keywords {
+		if strings.Contains(lowerContent, keyword) {
+			return &CommentRejectError{Filter: f.Name(), Reason: "包含不允许的内容"}
+		}
+	}
+	for _, re := range blocklist.patterns {
+		if re.MatchString(input.Comment.Content) {
+			return &CommentRejectError{Filter: f.Name(), Reason: "包含不允许的内容"}
+		}
+	}
+	return nil
+}
+
+var commentLinkRegexp = regexp.MustCompile(`(?i)\b(https?://|www\.)`)
+
+// 限制单条评论中的链接数量，COMMENT_MAX_LINKS默认为3
+type linkCommentFilter struct{}
+
+func (f *linkCommentFilter) Name() string {
+	return "link"
+}
+
+func (f *linkCommentFilter) Check(input *CommentFilterInput) error {
+	maxLinks := confighelper.GetInt("COMMENT_MAX_LINKS", 3)
+	if maxLinks < 0 {
+		return nil
+	}
+	linkCount := len(commentLinkRegexp.FindAllStringIndex(input.Comment.Content, -1))
+	if linkCount > maxLinks {
+		return &CommentRejectError{Filter: f.Name(), Reason: fmt.Sprintf("链接数量超过%d个", maxLinks)}
+	}
+	return nil
+}
+
+// 同一账号在COMMENT_DUPLICATE_WINDOW（默认24h）内不能重复发布相同内容
+type duplicateCommentFilter struct{}
+
+func (f *duplicateCommentFilter) Name() string {
+	return "duplicate"
+}
+
+func (f *duplicateCommentFilter) Check(input *CommentFilterInput) error {
+	window := confighelper.GetDuration("COMMENT_DUPLICATE_WINDOW", 24*time.Hour)
+	sqlText := `select count(1) as count from comments where creator = :creator 
+	and content = :content and create_time > :since;`
+	sqlParams := map[string]interface{}{
+		"creator": input.Comment.Creator,
+		"content": input.Comment.Content,
+		"since":   time.Now().UTC().Add(-window),
+	}
+	count, err := pgCountComments(sqlText, sqlParams)
+	if err != nil {
+		return fmt.Errorf("duplicateCommentFilter: %w", err)
+	}
+	if count > 0 {
+		return &CommentRejectError{Filter: f.Name(), Reason: "请勿重复发布相同内容"}
+	}
+	return nil
+}
+
+// 频率限制，在COMMENT_RATE_LIMIT_WINDOW（默认1m）内每个账号最多COMMENT_RATE_LIMIT_ACCOUNT条（默认5），
+// 每个IP最多COMMENT_RATE_LIMIT_IP条（默认10），配置为0表示不限制
+type rateLimitCommentFilter struct{}
+
+func (f *rateLimitCommentFilter) Name() string {
+	return "ratelimit"
+}
+
+func (f *rateLimitCommentFilter) Check(input *CommentFilterInput) error {
+	window := confighelper.GetDuration("COMMENT_RATE_LIMIT_WINDOW", time.Minute)
+	since := time.Now().UTC().Add(-window)
+
+	accountLimit := confighelper.GetInt("COMMENT_RATE_LIMIT_ACCOUNT", 5)
+	if accountLimit > 0 {
+		sqlText := `select count(1) as count from comments where creator = :creator and create_time > :since;`
+		sqlParams := map[string]interface{}{"creator": input.Comment.Creator, "since": since}
+		count, err := pgCountComments(sqlText, sqlParams)
+		if err != nil {
+			return fmt.Errorf("rateLimitCommentFilter: %w", err)
+		}
+		if count >= accountLimit {
+			return &CommentRejectError{Filter: f.Name(), Reason: "评论过于频繁，请稍后再试"}
+		}
+	}
+
+	ipLimit := confighelper.GetInt("COMMENT_RATE_LIMIT_IP", 10)
+	if ipLimit > 0 && input.Comment.IPAddress != "" {
+		sqlText := `select count(1) as count from comments where ipaddress = :ipaddress and create_time > :since;`
+		sqlParams := map[string]interface{}{"ipaddress": input.Comment.IPAddress, "since": since}
+		count, err := pgCountComments(sqlText, sqlParams)
+		if err != nil {
+			return fmt.Errorf("rateLimitCommentFilter: %w", err)
+		}
+		if count >= ipLimit {
+			return &CommentRejectError{Filter: f.Name(), Reason: "评论过于频繁，请稍后再试"}
+		}
+	}
+	return nil
+}
+
+// 真人校验，开启COMMENT_HUMAN_VERIFY后在云端服务模式下校验Turnstile令牌，本地主机和局域网模式跳过
+type humanVerifyCommentFilter struct{}
+
+func (f *humanVerifyCommentFilter) Name() string {
+	return "humanverify"
+}
+
+func (f *humanVerifyCommentFilter) Check(input *CommentFilterInput) error {
+	if !confighelper.GetBool("COMMENT_HUMAN_VERIFY", false) {
+		return nil
+	}
+	serveMode, _ := config.GetConfigurationString("SERVE_MODE")
+	if serveMode == "SELFHOST" || serveMode == "LOCALNET" {
+		return nil
+	}
+	verifyOk, err := cloudflare.VerifyTurnstileToken(input.TurnstileToken, input.Comment.IPAddress)
+	if err != nil || !verifyOk {
+		logrus.Infoln("humanVerifyCommentFilter", err)
+		return &CommentRejectError{Filter: f.Name(), Reason: "真人校验未通过"}
+	}
+	return nil
+}
+
+func pgCountComments(sqlText string, sqlParams map[string]interface{}) (int, error) {
+	var sqlResults []struct {
+		Count int `db:"count"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return 0, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return 0, fmt.Errorf("StructScan: %w", err)
+	}
+	if len(sqlResults) == 0 {
+		return 0, nil
+	}
+	return sqlResults[0].Count, nil
+}
+
+// 记录被拒绝的评论，便于人工复查过滤规则
+func PGInsertCommentRejection(model *CommentModel, rejectErr *CommentRejectError) error {
+	sqlText := `insert into comment_rejections(uid, creator, resource, ipaddress, content, filter, reason, create_time)
+values(:uid, :creator, :resource, :ipaddress, :content, :filter, :reason, now());`
+
+	sqlParams := map[string]interface{}{
+		"uid":       helpers.MustUuid(),
+		"creator":   model.Creator,
+		"resource":  model.Resource,
+		"ipaddress": model.IPAddress,
+		"content":   model.Content,
+		"filter":    rejectErr.Filter,
+		"reason":    rejectErr.Reason,
+	}
+
+	_, err := datastore.NamedExec(sqlText, sqlParams)
+	if err != nil {
+		return fmt.Errorf("PGInsertCommentRejection: %w", err)
+	}
+	return nil
+}
@@ -1,25 +1,23 @@
 package comments
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	nemodels "github.com/pnnh/neutron/models"
-	"portal/business/viewers"
 
-	"github.com/pnnh/neutron/config"
 	"github.com/pnnh/neutron/helpers"
-	"github.com/pnnh/neutron/services/redisdb"
 	"portal/business"
-	"portal/models"
+	"portal/business/cloudflare"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
 type CommentInsertRequest struct {
-	//cloudflare.TurnstileModel
+	cloudflare.TurnstileModel
 	CommentModel
 }
 
@@ -40,32 +38,248 @@ func CommentInsertHandler(gctx *gin.Context) {
 		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在或匿名用户不能评论"))
 		return
 	}
+	if strings.TrimSpace(request.Content) == "" || request.Resource == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("评论内容或资源不能为空"))
+		return
+	}
 
 	request.Uid = helpers.MustUuid()
 	request.CreateTime = time.Now().UTC()
 	request.UpdateTime = time.Now().UTC()
 	request.Creator = accountModel.Uid
-	request.Thread = helpers.EmptyUuid()
-	request.Referer = helpers.EmptyUuid()
+	if !resolveCommentReply(gctx, &request.CommentModel) {
+		return
+	}
 	request.IPAddress = helpers.GetIpAddress(gctx)
 	request.EMail = accountModel.EMail
 	request.Nickname = accountModel.Nickname
 	request.Website = accountModel.Website
+	request.Status = CommentStatusPending
+	if isTrustedCommenter(accountModel) {
+		request.Status = CommentStatusApproved
+	}
+
+	filterInput := &CommentFilterInput{
+		Comment:        &request.CommentModel,
+		Account:        accountModel,
+		TurnstileToken: request.TurnstileToken,
+	}
+	if !checkCommentFilters(gctx, RunCommentFilters(filterInput)) {
+		return
+	}
 
+	renderCommentContent(&request.CommentModel)
 	err = PGInsertComment(&request.CommentModel)
 	if err != nil {
 		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "插入评论出错"))
 		return
 	}
+	if err = PGReplaceCommentMentions(&request.CommentModel); err != nil {
+		logrus.Warnln("CommentInsertHandler 保存评论提及出错", err)
+	}
+	if request.Status == CommentStatusApproved {
+		notifyCommentApproved(&request.CommentModel)
+	}
+
+	result := nemodels.NECodeOk.WithData(map[string]any{
+		"changes":      1,
+		"uid":          request.Uid,
+		"status":       request.Status,
+		"content_html": request.ContentHtml,
+		"mentions":     request.Mentions,
+	})
+
+	gctx.JSON(http.StatusOK, result)
+}
+
+// 回复评论时referer为被回复的评论，thread为所在讨论的首条评论，不是回复时两者都为空uuid
+func resolveCommentReply(gctx *gin.Context, model *CommentModel) bool {
+	referer := model.Referer
+	model.Thread = helpers.EmptyUuid()
+	model.Referer = helpers.EmptyUuid()
+	if referer == "" || referer == helpers.EmptyUuid() {
+		return true
+	}
+	if !helpers.IsUuid(referer) {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("回复的评论不存在"))
+		return false
+	}
+	refererModel, err := PGGetComment(referer)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询回复的评论出错"))
+		return false
+	}
+	if refererModel == nil || refererModel.Deleted || refererModel.Status != CommentStatusApproved ||
+		refererModel.Resource != model.Resource {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("回复的评论不存在"))
+		return false
+	}
+	model.Referer = refererModel.Uid
+	model.Thread = refererModel.Uid
+	if refererModel.Thread != "" && refererModel.Thread != helpers.EmptyUuid() {
+		model.Thread = refererModel.Thread
+	}
+	return true
+}
+
+// 处理过滤器的执行结果，评论被拒绝或过滤出错时返回false
+func checkCommentFilters(gctx *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	var rejectErr *CommentRejectError
+	if errors.As(err, &rejectErr) {
+		logrus.Infoln("评论被拒绝", rejectErr)
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage(rejectErr.Reason))
+		return false
+	}
+	gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "检查评论出错"))
+	return false
+}
+
+type CommentUpdateRequest struct {
+	Content string `json:"content"`
+}
+
+// 查询当前登录用户自己发布的评论，非作者返回错误信息
+func findOwnComment(gctx *gin.Context, uid string) (*CommentModel, bool) {
+	accountModel, err := business.FindAccountFromCookie(gctx)
+	if err != nil {
+		logrus.Warnln("findOwnComment", err)
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询账号出错c"))
+		return nil, false
+	}
+	if accountModel == nil || accountModel.IsAnonymous() {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在或匿名用户不能修改评论"))
+		return nil, false
+	}
+	commentModel, err := PGGetComment(uid)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论出错"))
+		return nil, false
+	}
+	if commentModel == nil || commentModel.Deleted {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("评论不存在"))
+		return nil, false
+	}
+	if commentModel.Creator != accountModel.Uid {
+		gctx.JSON(http.StatusOK, nemodels.NECodeUnauthorized.WithMessage("没有权限修改该评论"))
+		return nil, false
+	}
+	return commentModel, true
+}
+
+func CommentUpdateHandler(gctx *gin.Context) {
+	uid := gctx.Param("uid")
+	if uid == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("uid不能为空"))
+		return
+	}
+	request := &CommentUpdateRequest{}
+	if err := gctx.ShouldBindJSON(request); err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithError(err))
+		return
+	}
+	if strings.TrimSpace(request.Content) == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("评论内容不能为空"))
+		return
+	}
+	commentModel, ok := findOwnComment(gctx, uid)
+	if !ok {
+		return
+	}
+	if commentModel.Content == request.Content {
+		gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(map[string]any{
+			"changes": 0,
+			"uid":     uid,
+		}))
+		return
+	}
+
+	commentModel.Content = request.Content
+	filterInput := &CommentFilterInput{
+		Comment: commentModel,
+	}
+	if !checkCommentFilters(gctx, RunCommentEditFilters(filterInput)) {
+		return
+	}
+	renderCommentContent(commentModel)
+	if err := PGUpdateCommentContent(commentModel); err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "更新评论出错"))
+		return
+	}
+	if err := PGReplaceCommentMentions(commentModel); err != nil {
+		logrus.Warnln("CommentUpdateHandler 保存评论提及出错", err)
+	}
+
+	result := nemodels.NECodeOk.WithData(map[string]any{
+		"changes":      1,
+		"uid":          uid,
+		"content_html": commentModel.ContentHtml,
+		"mentions":     commentModel.Mentions,
+	})
+
+	gctx.JSON(http.StatusOK, result)
+}
+
+func CommentDeleteHandler(gctx *gin.Context) {
+	uid := gctx.Param("uid")
+	if uid == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("uid不能为空"))
+		return
+	}
+	commentModel, ok := findOwnComment(gctx, uid)
+	if !ok {
+		return
+	}
+	if err := PGDeleteComment(commentModel); err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "删除评论出错"))
+		return
+	}
+	commentModel.Mentions = nil
+	if err := PGReplaceCommentMentions(commentModel); err != nil {
+		logrus.Warnln("CommentDeleteHandler 清除评论提及出错", err)
+	}
 
 	result := nemodels.NECodeOk.WithData(map[string]any{
 		"changes": 1,
-		"uid":     request.Uid,
+		"uid":     uid,
 	})
 
 	gctx.JSON(http.StatusOK, result)
 }
 
+// 查询评论的编辑历史，仅作者本人可见
+func CommentHistoryHandler(gctx *gin.Context) {
+	uid := gctx.Param("uid")
+	if uid == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("uid不能为空"))
+		return
+	}
+	commentModel, ok := findOwnComment(gctx, uid)
+	if !ok {
+		return
+	}
+	historyList, err := PGSelectCommentHistory(commentModel.Uid)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论历史出错"))
+		return
+	}
+
+	resultRange := make([]any, 0)
+	for _, item := range historyList {
+		resultRange = append(resultRange, item)
+	}
+	selectData := &nemodels.NESelectResponse{
+		Page:  1,
+		Size:  len(resultRange),
+		Count: len(resultRange),
+		Range: resultRange,
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(selectData))
+}
+
 func CommentSelectHandler(gctx *gin.Context) {
 	target := gctx.Query("resource")
 	if target == "" {
@@ -73,80 +287,36 @@ func CommentSelectHandler(gctx *gin.Context) {
 		return
 	}
 
-	selectResult, err := SelectComments(target, 1, 60)
+	accountModel, err := business.FindAccountFromCookie(gctx)
 	if err != nil {
-		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论出错"))
-		return
+		logrus.Warnln("FindAccountFromCookie查询账号出错d", err)
+	}
+	selectParams := &CommentSelectParams{
+		Resource: target,
+		Viewer:   helpers.EmptyUuid(),
+		Sort:     CommentSortNewest,
+	}
+	if gctx.Query("sort") == CommentSortTop {
+		selectParams.Sort = CommentSortTop
+	}
+	if accountModel != nil && !accountModel.IsAnonymous() {
+		selectParams.Viewer = accountModel.Uid
 	}
-	responseResult := nemodels.NECodeOk.WithData(selectResult)
 
-	accountModel, err := business.FindAccountFromCookie(gctx)
+	selectResult, err := SelectComments(selectParams, 1, 60)
 	if err != nil {
-		logrus.Warnln("FindAccountFromCookie查询账号出错d", err)
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论出错"))
+		return
 	}
+	responseResult := nemodels.NECodeOk.WithData(selectResult)
 
-	//addr := helpers.GetIpAddress(gctx)
+	addr := helpers.GetIpAddress(gctx)
 	isBotRequest, userAgent := helpers.IsBotRequest(gctx)
 	if !isBotRequest && accountModel != nil && !accountModel.IsAnonymous() {
-		// 发送评论消息到消息队列
-		//sendCommentViewerMQMessages(gctx, accountModel, selectResult, addr)
+		// 发送评论浏览记录到消息队列
+		sendCommentViewerMQMessages(accountModel, selectResult, addr)
 	} else {
 		logrus.Infoln("CommentSelectHandler isBotRequest:", userAgent, "accountModel:", accountModel)
 	}
 	gctx.JSON(http.StatusOK, responseResult)
 }
-
-// 发送评论消息到消息队列
-func sendCommentViewerMQMessages(gctx *gin.Context, accountModel *models.AccountModel,
-	selectResult *nemodels.NESelectResponse, addr string) {
-
-	commentViewers := make([]*viewers.MTViewerModel, 0)
-	for _, item := range selectResult.Range {
-		comment := item.(*CommentModel)
-		// 跳过匿名评论或当前用户的评论
-		if comment == nil || comment.Creator == "" {
-			continue
-		}
-		model := &viewers.MTViewerModel{
-			MTViewerTable: viewers.MTViewerTable{
-				Uid:        helpers.MustUuid(),
-				Target:     comment.Uid,
-				Address:    addr,
-				CreateTime: time.Now(),
-				UpdateTime: time.Now(),
-				Class:      "comment",
-			},
-		}
-		if accountModel != nil && !accountModel.IsAnonymous() {
-			if comment.Creator == accountModel.Uid {
-				continue
-			}
-			model.Source = accountModel.Uid
-		}
-		commentViewers = append(commentViewers, model)
-	}
-	if len(commentViewers) > 0 {
-		viewersJson, err := json.Marshal(commentViewers)
-		if err != nil {
-			logrus.Errorln("CommentSelectHandler json.Marshal error:", err)
-			gctx.JSON(http.StatusOK, nemodels.NECodeError.WithError(err))
-			return
-		}
-		redisUrl, ok := config.GetConfigurationString("REDIS_URL")
-		if !ok || redisUrl == "" {
-			logrus.Fatalln("REDIS_URL未配置")
-		}
-		redisClient, err := redisdb.ConnectRedis(gctx, redisUrl)
-		if err != nil {
-			logrus.Errorln("CommentSelectHandler ConnectRedis error:", err)
-			gctx.JSON(http.StatusOK, nemodels.NECodeError.WithError(err))
-			return
-		}
-		err = redisdb.Produce(gctx, redisClient, CommentViewersRedisKey, viewersJson)
-		if err != nil {
-			logrus.Errorln("CommentSelectHandler Producer error:", err)
-			gctx.JSON(http.StatusOK, nemodels.NECodeError.WithError(err))
-			return
-		}
-	}
-}
@@ -0,0 +1,120 @@
+package comments
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+	"portal/models"
+	"portal/services/markdown"
+)
+
+// 评论中@提及的账号
+type CommentMentionModel struct {
+	Comment string `json:"comment"`
+	Account string `json:"account"`
+	Name    string `json:"name"`
+}
+
+func resolveCommentMention(name string) (string, bool) {
+	accountModel, err := models.GetAccountByUsername(name)
+	if err != nil {
+		logrus.Warnln("resolveCommentMention", name, err)
+		return "", false
+	}
+	if accountModel == nil || accountModel.IsAnonymous() {
+		return "", false
+	}
+	return accountModel.Uid, true
+}
+
+// 渲染评论内容，生成过滤后的HTML并解析@提及的账号
+func renderCommentContent(model *CommentModel) {
+	result := markdown.Render(model.Content, &markdown.Options{
+		ResolveMention: resolveCommentMention,
+	})
+	model.ContentHtml = result.HTML
+	model.Mentions = result.Mentions
+}
+
+// 历史评论没有content_html时在读取时补充渲染，不再解析提及
+func ensureCommentHtml(model *CommentModel) {
+	if model.ContentHtml == "" && model.Content != "" {
+		model.ContentHtml = markdown.Render(model.Content, nil).HTML
+	}
+}
+
+// PGReplaceCommentMentions 用本次解析结果替换评论的提及记录
+func PGReplaceCommentMentions(model *CommentModel) (opErr error) {
+	sqlTx, err := datastore.NewTranscation()
+	if err != nil {
+		return fmt.Errorf("PGReplaceCommentMentions: %w", err)
+	}
+	defer func() {
+		if opErr != nil {
+			if err := sqlTx.Rollback(); err != nil {
+				opErr = fmt.Errorf("%w\nRollback: %v", opErr, err)
+			}
+		}
+	}()
+
+	deleteRows, err := sqlTx.NamedQuery(`delete from comment_mentions where comment = :comment;`,
+		map[string]interface{}{"comment": model.Uid})
+	if err != nil {
+		return fmt.Errorf("PGReplaceCommentMentions delete: %w", err)
+	}
+	if err = deleteRows.Close(); err != nil {
+		return fmt.Errorf("PGReplaceCommentMentions delete close: %w", err)
+	}
+
+	insertText := `insert into comment_mentions(comment, account, name, create_time)
+values(:comment, :account, :name, now()) on conflict do nothing;`
+	for _, mention := range model.Mentions {
+		insertParams := map[string]interface{}{
+			"comment": model.Uid,
+			"account": mention.Uid,
+			"name":    mention.Name,
+		}
+		insertRows, err := sqlTx.NamedQuery(insertText, insertParams)
+		if err != nil {
+			return fmt.Errorf("PGReplaceCommentMentions insert: %w", err)
+		}
+		if err = insertRows.Close(); err != nil {
+			return fmt.Errorf("PGReplaceCommentMentions insert close: %w", err)
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("PGReplaceCommentMentions Commit: %w", err)
+	}
+	return nil
+}
+
+// 查询指定评论的提及记录，按评论uid分组
+func pgSelectCommentsMentions(uids []string) (map[string][]*markdown.Mention, error) {
+	mentionMap := make(map[string][]*markdown.Mention)
+	if len(uids) == 0 {
+		return mentionMap, nil
+	}
+	sqlText := `select m.comment, m.account, m.name from comment_mentions m
+    where m.comment::text = any(string_to_array(:uids, ','));`
+
+	sqlParams := map[string]interface{}{"uids": strings.Join(uids, ",")}
+	var sqlResults []*CommentMentionModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	for _, item := range sqlResults {
+		mentionMap[item.Comment] = append(mentionMap[item.Comment],
+			&markdown.Mention{Name: item.Name, Uid: item.Account})
+	}
+	return mentionMap, nil
+}
@@ -0,0 +1,273 @@
+package comments
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"portal/business"
+	"portal/models"
+	"portal/services/confighelper"
+
+	"github.com/pnnh/neutron/helpers"
+	nemodels "github.com/pnnh/neutron/models"
+	"github.com/pnnh/neutron/services/datastore"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// 判断账号发布的评论是否可以免审核直接通过
+// COMMENT_AUTO_APPROVE 为 false 时所有评论都需要审核；
+// 管理员和 COMMENT_TRUSTED_ACCOUNTS 中的账号直接通过；
+// COMMENT_AUTO_APPROVE_THRESHOLD 大于0时，已通过评论数达到该值的账号也直接通过
+func isTrustedCommenter(accountModel *models.AccountModel) bool {
+	if !confighelper.GetBool("COMMENT_AUTO_APPROVE", true) {
+		return false
+	}
+	if business.IsAdminAccount(accountModel) {
+		return true
+	}
+	for _, uid := range confighelper.GetStringList("COMMENT_TRUSTED_ACCOUNTS") {
+		if uid == accountModel.Uid {
+			return true
+		}
+	}
+	threshold := confighelper.GetInt("COMMENT_AUTO_APPROVE_THRESHOLD", 0)
+	if threshold <= 0 {
+		return false
+	}
+	approvedCount, err := pgCountApprovedComments(accountModel.Uid)
+	if err != nil {
+		logrus.Warnln("isTrustedCommenter", err)
+		return false
+	}
+	return approvedCount >= threshold
+}
+
+func pgCountApprovedComments(creator string) (int, error) {
+	sqlText := `select count(1) as count from comments where creator = :creator and status = 1;`
+	sqlParams := map[string]interface{}{"creator": creator}
+
+	return pgCountComments(sqlText, sqlParams)
+}
+
+// 查询评论所属资源的所有者，资源可以是文章或文件
+func pgGetResourceOwner(resource string) (string, error) {
+	sqlText := `select owner::text as owner from community.articles where uid = :resource
+union all
+select owner::text as owner from community.files where uid = :resource
+limit 1;`
+
+	sqlParams := map[string]interface{}{"resource": resource}
+	var sqlResults []struct {
+		Owner string `db:"owner"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return "", fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return "", fmt.Errorf("StructScan: %w", err)
+	}
+	for _, item := range sqlResults {
+		return item.Owner, nil
+	}
+	return "", nil
+}
+
+// 判断账号是否可以审核某条评论，管理员可以审核全部评论，资源所有者可以审核自己资源下的评论
+func canModerateComment(accountModel *models.AccountModel, commentModel *CommentModel) (bool, error) {
+	if business.IsAdminAccount(accountModel) {
+		return true, nil
+	}
+	owner, err := pgGetResourceOwner(commentModel.Resource)
+	if err != nil {
+		return false, fmt.Errorf("canModerateComment: %w", err)
+	}
+	return owner != "" && owner == accountModel.Uid, nil
+}
+
+// 查询待审核的评论，owner为空时查询全部待审核评论
+func SelectPendingComments(owner string, page int, size int) (*nemodels.NESelectResponse, error) {
+	pagination := helpers.CalcPaginationByPage(page, size)
+	baseSqlParams := map[string]interface{}{}
+
+	baseSqlText := ` select * from comments where status = 0 and deleted = false `
+	if owner != "" {
+		baseSqlText += ` and resource in (select uid from community.articles where owner = :owner
+                        union select uid from community.files where owner = :owner) `
+		baseSqlParams["owner"] = owner
+	}
+
+	pageSqlText := baseSqlText + ` order by create_time asc offset :offset limit :limit; `
+	pageSqlParams := map[string]interface{}{
+		"offset": pagination.Offset, "limit": pagination.Limit}
+	for k, v := range baseSqlParams {
+		pageSqlParams[k] = v
+	}
+	var sqlResults []*CommentModel
+
+	rows, err := datastore.NamedQuery(pageSqlText, pageSqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	resultRange := make([]any, 0)
+	for _, item := range sqlResults {
+		ensureCommentHtml(item)
+		resultRange = append(resultRange, item)
+	}
+
+	countSqlText := `select count(1) as count from (` + baseSqlText + `) as temp;`
+	var countSqlResults []struct {
+		Count int `db:"count"`
+	}
+
+	rows, err = datastore.NamedQuery(countSqlText, baseSqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &countSqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	if len(countSqlResults) == 0 {
+		return nil, fmt.Errorf("查询待审核评论总数有误，数据为空")
+	}
+
+	selectData := &nemodels.NESelectResponse{
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+		Count: countSqlResults[0].Count,
+		Range: resultRange,
+	}
+
+	return selectData, nil
+}
+
+func PGUpdateCommentStatus(uid string, status int) error {
+	sqlText := `update comments set status = :status, update_time = now() where uid = :uid;`
+
+	sqlParams := map[string]interface{}{
+		"uid":    uid,
+		"status": status,
+	}
+
+	_, err := datastore.NamedExec(sqlText, sqlParams)
+	if err != nil {
+		return fmt.Errorf("PGUpdateCommentStatus: %w", err)
+	}
+	return nil
+}
+
+// 待审核评论列表，管理员可以看到全部，资源所有者只能看到自己资源下的评论
+func CommentPendingHandler(gctx *gin.Context) {
+	pageInt, err := strconv.Atoi(gctx.Query("page"))
+	if err != nil {
+		pageInt = 1
+	}
+	sizeInt, err := strconv.Atoi(gctx.Query("size"))
+	if err != nil {
+		sizeInt = 20
+	}
+	accountModel, err := business.FindAccountFromCookie(gctx)
+	if err != nil {
+		logrus.Warnln("CommentPendingHandler", err)
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询账号出错b"))
+		return
+	}
+	if accountModel == nil || accountModel.IsAnonymous() {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在"))
+		return
+	}
+	owner := accountModel.Uid
+	if business.IsAdminAccount(accountModel) {
+		owner = ""
+	}
+
+	selectResult, err := SelectPendingComments(owner, pageInt, sizeInt)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询待审核评论出错"))
+		return
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(selectResult))
+}
+
+type CommentModerateRequest struct {
+	Action string `json:"action"` // approve, reject, spam
+}
+
+var commentModerateActions = map[string]int{
+	"approve": CommentStatusApproved,
+	"reject":  CommentStatusRejected,
+	"spam":    CommentStatusSpam,
+}
+
+func CommentModerateHandler(gctx *gin.Context) {
+	uid := gctx.Param("uid")
+	if uid == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("uid不能为空"))
+		return
+	}
+	request := &CommentModerateRequest{}
+	if err := gctx.ShouldBindJSON(request); err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithError(err))
+		return
+	}
+	newStatus, ok := commentModerateActions[request.Action]
+	if !ok {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("不支持的审核操作"))
+		return
+	}
+	accountModel, err := business.FindAccountFromCookie(gctx)
+	if err != nil {
+		logrus.Warnln("CommentModerateHandler", err)
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询账号出错c"))
+		return
+	}
+	if accountModel == nil || accountModel.IsAnonymous() {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在或匿名用户不能审核评论"))
+		return
+	}
+	commentModel, err := PGGetComment(uid)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论出错"))
+		return
+	}
+	if commentModel == nil {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("评论不存在"))
+		return
+	}
+	allowed, err := canModerateComment(accountModel, commentModel)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论资源出错"))
+		return
+	}
+	if !allowed {
+		gctx.JSON(http.StatusOK, nemodels.NECodeUnauthorized.WithMessage("没有权限审核该评论"))
+		return
+	}
+
+	if err = PGUpdateCommentStatus(uid, newStatus); err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "审核评论出错"))
+		return
+	}
+	logrus.Infoln("CommentModerateHandler", accountModel.Uid, request.Action, uid)
+	if newStatus == CommentStatusApproved && commentModel.Status != CommentStatusApproved && !commentModel.Deleted {
+		notifyCommentApproved(commentModel)
+	}
+
+	result := nemodels.NECodeOk.WithData(map[string]any{
+		"changes": 1,
+		"uid":     uid,
+		"status":  newStatus,
+	})
+
+	gctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,139 @@
+package comments
+
+import (
+	"fmt"
+
+	"portal/business/notifications"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/helpers"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// 通知中保留的评论摘要长度
+const notificationExcerptLength = 140
+
+// 查询参与过某个讨论的账号，包括讨论的首条评论和所有已通过的回复
+func pgSelectThreadParticipants(thread string) ([]string, error) {
+	sqlText := `select distinct creator::text as creator from comments
+where (uid = :thread or thread = :thread) and status = 1 and deleted = false;`
+
+	sqlParams := map[string]interface{}{"thread": thread}
+	var sqlResults []struct {
+		Creator string `db:"creator"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	participants := make([]string, 0, len(sqlResults))
+	for _, item := range sqlResults {
+		participants = append(participants, item.Creator)
+	}
+	return participants, nil
+}
+
+func pgSelectCommentMentions(comment string) ([]*CommentMentionModel, error) {
+	sqlText := `select comment, account, name from comment_mentions where comment = :comment;`
+
+	sqlParams := map[string]interface{}{"comment": comment}
+	var sqlResults []*CommentMentionModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+// 计算评论需要通知的账号，每个账号只通知一次，按回复、提及、资源所有者、讨论参与者的优先级确定通知类型
+func commentNotificationRecipients(model *CommentModel) ([]*notifications.NotificationModel, error) {
+	recipients := make([]*notifications.NotificationModel, 0)
+	notified := map[string]bool{
+		"":                  true,
+		helpers.EmptyUuid(): true,
+		model.Creator:       true,
+	}
+	addRecipient := func(account string, kind string) {
+		if notified[account] {
+			return
+		}
+		notified[account] = true
+		recipients = append(recipients, &notifications.NotificationModel{
+			Account:  account,
+			Kind:     kind,
+			Actor:    model.Creator,
+			Resource: model.Resource,
+			Comment:  model.Uid,
+			Content:  commentExcerpt(model.Content),
+		})
+	}
+
+	if model.Referer != "" && model.Referer != helpers.EmptyUuid() {
+		refererModel, err := PGGetComment(model.Referer)
+		if err != nil {
+			return nil, fmt.Errorf("PGGetComment: %w", err)
+		}
+		if refererModel != nil && !refererModel.Deleted {
+			addRecipient(refererModel.Creator, notifications.NotificationKindReply)
+		}
+	}
+
+	mentionList, err := pgSelectCommentMentions(model.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("pgSelectCommentMentions: %w", err)
+	}
+	for _, item := range mentionList {
+		addRecipient(item.Account, notifications.NotificationKindMention)
+	}
+
+	owner, err := pgGetResourceOwner(model.Resource)
+	if err != nil {
+		return nil, fmt.Errorf("pgGetResourceOwner: %w", err)
+	}
+	addRecipient(owner, notifications.NotificationKindComment)
+
+	if model.Thread != "" && model.Thread != helpers.EmptyUuid() {
+		participants, err := pgSelectThreadParticipants(model.Thread)
+		if err != nil {
+			return nil, fmt.Errorf("pgSelectThreadParticipants: %w", err)
+		}
+		for _, account := range participants {
+			addRecipient(account, notifications.NotificationKindThread)
+		}
+	}
+
+	return recipients, nil
+}
+
+// 评论审核通过后通知相关账号，通知失败不影响评论本身
+func notifyCommentApproved(model *CommentModel) {
+	recipients, err := commentNotificationRecipients(model)
+	if err != nil {
+		logrus.Warnln("notifyCommentApproved 计算通知对象出错", model.Uid, err)
+		return
+	}
+	if len(recipients) == 0 {
+		return
+	}
+	if err = notifications.PGInsertNotifications(recipients...); err != nil {
+		logrus.Warnln("notifyCommentApproved 写入通知出错", model.Uid, err)
+	}
+}
+
+func commentExcerpt(content string) string {
+	runes := []rune(content)
+	if len(runes) <= notificationExcerptLength {
+		return content
+	}
+	return string(runes[:notificationExcerptLength]) + "…"
+}
@@ -0,0 +1,257 @@
+package comments
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"portal/business"
+
+	nemodels "github.com/pnnh/neutron/models"
+	"github.com/pnnh/neutron/services/datastore"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// 评论支持的反应，up和down同时用于评论投票排序
+const (
+	CommentReactionUp       = "up"
+	CommentReactionDown     = "down"
+	CommentReactionHeart    = "heart"
+	CommentReactionLaugh    = "laugh"
+	CommentReactionHooray   = "hooray"
+	CommentReactionEyes     = "eyes"
+	CommentReactionRocket   = "rocket"
+	CommentReactionConfused = "confused"
+)
+
+var commentReactions = map[string]bool{
+	CommentReactionUp:       true,
+	CommentReactionDown:     true,
+	CommentReactionHeart:    true,
+	CommentReactionLaugh:    true,
+	CommentReactionHooray:   true,
+	CommentReactionEyes:     true,
+	CommentReactionRocket:   true,
+	CommentReactionConfused: true,
+}
+
+func IsCommentReaction(reaction string) bool {
+	return commentReactions[reaction]
+}
+
+// 评论列表排序方式
+const (
+	CommentSortNewest = "newest"
+	CommentSortTop    = "top"
+)
+
+// top排序的得分为赞成数减去反对数
+const commentScoreSqlText = `(select count(1) filter (where r.reaction = 'up') - count(1) filter (where r.reaction = 'down')
+    from comment_reactions r where r.comment = comments.uid)`
+
+type commentReactionCount struct {
+	Comment  string `db:"comment"`
+	Reaction string `db:"reaction"`
+	Count    int    `db:"count"`
+	Mine     bool   `db:"mine"`
+}
+
+// 按评论聚合指定评论的反应数量，同时标记查看者自己的反应
+func pgSelectCommentsReactions(uids []string, viewer string) ([]*commentReactionCount, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	sqlText := `select r.comment, r.reaction, count(1) as count, bool_or(r.account = :viewer) as mine
+from comment_reactions r where r.comment::text = any(string_to_array(:uids, ','))
+group by r.comment, r.reaction;`
+
+	sqlParams := map[string]interface{}{"uids": strings.Join(uids, ","), "viewer": viewer}
+	var sqlResults []*commentReactionCount
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+func pgSelectCommentReactions(comment string, viewer string) ([]*commentReactionCount, error) {
+	sqlText := `select r.comment, r.reaction, count(1) as count, bool_or(r.account = :viewer) as mine
+from comment_reactions r where r.comment = :comment group by r.comment, r.reaction;`
+
+	sqlParams := map[string]interface{}{"comment": comment, "viewer": viewer}
+	var sqlResults []*commentReactionCount
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+// 把聚合结果填充到评论模型中
+func fillCommentReactions(model *CommentModel, counts []*commentReactionCount) {
+	model.Reactions = make(map[string]int)
+	model.MyReactions = make([]string, 0)
+	for _, item := range counts {
+		if item.Comment != model.Uid {
+			continue
+		}
+		model.Reactions[item.Reaction] = item.Count
+		if item.Mine {
+			model.MyReactions = append(model.MyReactions, item.Reaction)
+		}
+	}
+	model.Score = model.Reactions[CommentReactionUp] - model.Reactions[CommentReactionDown]
+}
+
+// PGToggleCommentReaction 已存在的反应会被取消，否则新增，返回操作后是否处于已反应状态
+// 在一个事务中完成，并按评论和账号加advisory锁，同一账号并发切换赞成和反对时不会同时保留两者
+func PGToggleCommentReaction(comment string, account string, reaction string) (reacted bool, opErr error) {
+	sqlTx, err := datastore.NewTranscation()
+	if err != nil {
+		return false, fmt.Errorf("PGToggleCommentReaction: %w", err)
+	}
+	defer func() {
+		if opErr != nil {
+			if err := sqlTx.Rollback(); err != nil {
+				opErr = fmt.Errorf("%w\nRollback: %v", opErr, err)
+			}
+		}
+	}()
+	// 事务中没有NamedExec，通过returning统计影响的行数
+	txExec := func(sqlText string, sqlParams map[string]interface{}) (int, error) {
+		rows, err := sqlTx.NamedQuery(sqlText, sqlParams)
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for rows.Next() {
+			count += 1
+		}
+		if err := rows.Err(); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		return count, rows.Close()
+	}
+	sqlParams := map[string]interface{}{
+		"comment":  comment,
+		"account":  account,
+		"reaction": reaction,
+	}
+
+	lockText := `select pg_advisory_xact_lock(hashtext(:comment), hashtext(:account));`
+	if _, err = txExec(lockText, sqlParams); err != nil {
+		return false, fmt.Errorf("PGToggleCommentReaction lock: %w", err)
+	}
+
+	deleteText := `delete from comment_reactions
+where comment = :comment and account = :account and reaction = :reaction returning reaction;`
+	deleted, err := txExec(deleteText, sqlParams)
+	if err != nil {
+		return false, fmt.Errorf("PGToggleCommentReaction delete: %w", err)
+	}
+
+	if deleted == 0 {
+		// 赞成和反对互斥，投票时撤销相反的一票
+		opposite := map[string]string{
+			CommentReactionUp:   CommentReactionDown,
+			CommentReactionDown: CommentReactionUp,
+		}[reaction]
+		if opposite != "" {
+			oppositeParams := map[string]interface{}{
+				"comment":  comment,
+				"account":  account,
+				"reaction": opposite,
+			}
+			if _, err = txExec(deleteText, oppositeParams); err != nil {
+				return false, fmt.Errorf("PGToggleCommentReaction opposite: %w", err)
+			}
+		}
+
+		insertText := `insert into comment_reactions(comment, account, reaction, create_time)
+values(:comment, :account, :reaction, now()) on conflict do nothing returning reaction;`
+		if _, err = txExec(insertText, sqlParams); err != nil {
+			return false, fmt.Errorf("PGToggleCommentReaction insert: %w", err)
+		}
+	}
+
+	if err = sqlTx.Commit(); err != nil {
+		return false, fmt.Errorf("PGToggleCommentReaction Commit: %w", err)
+	}
+	return deleted == 0, nil
+}
+
+type CommentReactionRequest struct {
+	Reaction string `json:"reaction"`
+}
+
+func CommentReactionHandler(gctx *gin.Context) {
+	uid := gctx.Param("uid")
+	if uid == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("uid不能为空"))
+		return
+	}
+	request := &CommentReactionRequest{}
+	if err := gctx.ShouldBindJSON(request); err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithError(err))
+		return
+	}
+	if !IsCommentReaction(request.Reaction) {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("不支持的评论反应"))
+		return
+	}
+
+	accountModel, err := business.FindAccountFromCookie(gctx)
+	if err != nil {
+		logrus.Warnln("CommentReactionHandler", err)
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询账号出错c"))
+		return
+	}
+	if accountModel == nil || accountModel.IsAnonymous() {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在或匿名用户不能操作"))
+		return
+	}
+	commentModel, err := PGGetComment(uid)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论出错"))
+		return
+	}
+	if commentModel == nil || commentModel.Deleted || commentModel.Status != CommentStatusApproved {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("评论不存在"))
+		return
+	}
+
+	reacted, err := PGToggleCommentReaction(commentModel.Uid, accountModel.Uid, request.Reaction)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "更新评论反应出错"))
+		return
+	}
+	counts, err := pgSelectCommentReactions(commentModel.Uid, accountModel.Uid)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询评论反应出错"))
+		return
+	}
+	fillCommentReactions(commentModel, counts)
+
+	result := nemodels.NECodeOk.WithData(map[string]any{
+		"uid":          commentModel.Uid,
+		"reaction":     request.Reaction,
+		"reacted":      reacted,
+		"reactions":    commentModel.Reactions,
+		"my_reactions": commentModel.MyReactions,
+		"score":        commentModel.Score,
+	})
+
+	gctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,170 @@
+package comments
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"portal/business/viewers"
+	"portal/models"
+	"portal/services/queue"
+
+	"github.com/pnnh/neutron/helpers"
+	nemodels "github.com/pnnh/neutron/models"
+	"github.com/sirupsen/logrus"
+)
+
+// 评论浏览记录先在进程内缓冲，攒够一批或到达间隔后一次写入消息队列
+const (
+	commentViewerFlushSize     = 100
+	commentViewerFlushInterval = 500 * time.Millisecond
+	commentViewerBufferSize    = 2000
+)
+
+type commentViewerProducer struct {
+	queue   queue.Queue
+	viewers chan *viewers.MTViewerModel
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+var (
+	viewerProducerOnce sync.Once
+	viewerProducer     *commentViewerProducer
+)
+
+// 打开消息队列失败时返回nil，此时不记录评论浏览
+func getCommentViewerProducer() *commentViewerProducer {
+	viewerProducerOnce.Do(func() {
+		viewerQueue, err := queue.Open(context.Background())
+		if err != nil {
+			logrus.Errorln("评论浏览记录打开消息队列出错:", err)
+			return
+		}
+		producer := &commentViewerProducer{
+			queue:   viewerQueue,
+			viewers: make(chan *viewers.MTViewerModel, commentViewerBufferSize),
+			done:    make(chan struct{}),
+			stopped: make(chan struct{}),
+		}
+		go producer.run()
+		viewerProducer = producer
+	})
+	return viewerProducer
+}
+
+// 浏览记录允许丢失，缓冲区满时直接丢弃，不阻塞请求
+func (p *commentViewerProducer) add(viewerModels ...*viewers.MTViewerModel) {
+	for _, model := range viewerModels {
+		select {
+		case p.viewers <- model:
+		default:
+			logrus.Warnln("评论浏览记录缓冲区已满，丢弃", len(viewerModels))
+			return
+		}
+	}
+}
+
+// StopCommentViewerProducer 服务退出前把缓冲中的浏览记录写入消息队列并关闭队列
+func StopCommentViewerProducer() {
+	// 尚未创建时不再创建，已创建时保证读取到完整的producer
+	viewerProducerOnce.Do(func() {})
+	producer := viewerProducer
+	if producer == nil {
+		return
+	}
+	close(producer.done)
+	<-producer.stopped
+	if err := producer.queue.Close(); err != nil {
+		logrus.Warnln("关闭评论浏览记录消息队列出错:", err)
+	}
+}
+
+func (p *commentViewerProducer) run() {
+	ticker := time.NewTicker(commentViewerFlushInterval)
+	defer ticker.Stop()
+	defer close(p.stopped)
+
+	batch := make([]*viewers.MTViewerModel, 0, commentViewerFlushSize)
+	for {
+		select {
+		case <-p.done:
+			for {
+				select {
+				case model := <-p.viewers:
+					batch = append(batch, model)
+					if len(batch) >= commentViewerFlushSize {
+						p.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						p.flush(batch)
+					}
+					return
+				}
+			}
+		case model := <-p.viewers:
+			batch = append(batch, model)
+			if len(batch) >= commentViewerFlushSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (p *commentViewerProducer) flush(batch []*viewers.MTViewerModel) {
+	message, err := json.Marshal(&viewers.MTViewerMessage{Viewers: batch})
+	if err != nil {
+		logrus.Errorln("评论浏览记录序列化出错:", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = p.queue.Push(ctx, CommentViewersRedisKey, message); err != nil {
+		logrus.Errorln("评论浏览记录写入消息队列出错:", err, len(batch))
+	}
+}
+
+// 发送评论浏览记录到消息队列，跳过当前用户自己的评论
+func sendCommentViewerMQMessages(accountModel *models.AccountModel,
+	selectResult *nemodels.NESelectResponse, addr string) {
+	producer := getCommentViewerProducer()
+	if producer == nil {
+		return
+	}
+
+	commentViewers := make([]*viewers.MTViewerModel, 0)
+	for _, item := range selectResult.Range {
+		comment, ok := item.(*CommentModel)
+		// 跳过匿名评论
+		if !ok || comment == nil || comment.Creator == "" {
+			continue
+		}
+		model := &viewers.MTViewerModel{
+			MTViewerTable: viewers.MTViewerTable{
+				Uid:        helpers.MustUuid(),
+				Target:     comment.Uid,
+				Address:    addr,
+				CreateTime: time.Now(),
+				UpdateTime: time.Now(),
+				Class:      "comment",
+			},
+		}
+		if accountModel != nil && !accountModel.IsAnonymous() {
+			if comment.Creator == accountModel.Uid {
+				continue
+			}
+			model.Source = accountModel.Uid
+		}
+		commentViewers = append(commentViewers, model)
+	}
+	producer.add(commentViewers...)
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"net/http"
+	"strconv"
+
+	"portal/business"
+	jobservice "portal/services/jobs"
+
+	nemodels "github.com/pnnh/neutron/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 任务状态接口仅对管理员开放
+func checkAdmin(gctx *gin.Context) bool {
+	accountModel, err := business.FindAccountFromCookie(gctx)
+	if err != nil {
+		logrus.Warnln("checkAdmin", err)
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询账号出错c"))
+		return false
+	}
+	if !business.IsAdminAccount(accountModel) {
+		gctx.JSON(http.StatusOK, nemodels.NECodeUnauthorized.WithMessage("没有权限查看任务"))
+		return false
+	}
+	return true
+}
+
+func JobSelectHandler(gctx *gin.Context) {
+	pageInt, err := strconv.Atoi(gctx.Query("page"))
+	if err != nil {
+		pageInt = 1
+	}
+	sizeInt, err := strconv.Atoi(gctx.Query("size"))
+	if err != nil {
+		sizeInt = 20
+	}
+	if !checkAdmin(gctx) {
+		return
+	}
+	selectParams := &jobservice.JobSelectParams{
+		Name:   gctx.Query("name"),
+		Queue:  gctx.Query("queue"),
+		Status: gctx.Query("status"),
+	}
+
+	selectResult, err := jobservice.SelectJobs(selectParams, pageInt, sizeInt)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询任务出错"))
+		return
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(selectResult))
+}
+
+func JobGetHandler(gctx *gin.Context) {
+	uid := gctx.Param("uid")
+	if uid == "" {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("uid不能为空"))
+		return
+	}
+	if !checkAdmin(gctx) {
+		return
+	}
+	model, err := jobservice.PGGetJob(uid)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询任务出错"))
+		return
+	}
+	if model == nil {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("任务不存在"))
+		return
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(model))
+}
+
+// 按队列和状态统计任务数量，同时返回消息队列中各队列待执行和延时任务的数量
+func JobStatsHandler(gctx *gin.Context) {
+	if !checkAdmin(gctx) {
+		return
+	}
+	stats, err := jobservice.PGSelectJobStats()
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "统计任务出错"))
+		return
+	}
+	result := map[string]any{
+		"stats": stats,
+	}
+
+	// 消息队列不可用时仍返回数据库中的统计
+	queueLengths, delayed, err := jobservice.QueueLengths(gctx)
+	if err != nil {
+		logrus.Warnln("JobStatsHandler", err)
+	} else {
+		result["queues"] = queueLengths
+		result["delayed"] = delayed
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(result))
+}
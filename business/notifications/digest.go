@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"portal/models"
+	"portal/services/jobs"
+	"portal/services/mailer"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// 通知摘要任务，由worker进程执行，可以由定时器或其他进程提交
+const JobNotificationDigest = "notifications.digest"
+
+// EnqueueDigest 提交通知摘要任务，失败时不重试，由下个周期重新提交
+func EnqueueDigest(ctx context.Context) error {
+	if _, err := jobs.Enqueue(ctx, JobNotificationDigest, nil, &jobs.EnqueueOptions{MaxAttempts: 1}); err != nil {
+		return fmt.Errorf("EnqueueDigest: %w", err)
+	}
+	return nil
+}
+
+// 每封摘要邮件最多列出的通知数量
+const digestMaxItems = 20
+
+var notificationKindTitles = map[string]string{
+	NotificationKindComment: "你的内容收到了新评论",
+	NotificationKindReply:   "你的评论收到了回复",
+	NotificationKindMention: "有人在评论中提到了你",
+	NotificationKindThread:  "你参与的讨论有新回复",
+}
+
+type digestAccount struct {
+	Account string    `db:"account"`
+	Count   int       `db:"count"`
+	Until   time.Time `db:"until"`
+}
+
+func pgSelectDigestAccounts() ([]*digestAccount, error) {
+	sqlText := `select account, count(1) as count, max(create_time) as until from notifications
+where read = false and emailed = false group by account limit 200;`
+	var sqlResults []*digestAccount
+
+	rows, err := datastore.NamedQuery(sqlText, map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+func pgSelectDigestNotifications(item *digestAccount) ([]*NotificationModel, error) {
+	sqlText := `select * from notifications where account = :account and read = false and emailed = false
+    and create_time <= :until order by create_time desc limit :limit;`
+	sqlParams := map[string]interface{}{
+		"account": item.Account,
+		"until":   item.Until,
+		"limit":   digestMaxItems,
+	}
+	var sqlResults []*NotificationModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+func pgMarkNotificationsEmailed(item *digestAccount) error {
+	sqlText := `update notifications set emailed = true, update_time = now()
+where account = :account and emailed = false and create_time <= :until;`
+	sqlParams := map[string]interface{}{
+		"account": item.Account,
+		"until":   item.Until,
+	}
+	if _, err := pgExecNotifications(sqlText, sqlParams); err != nil {
+		return fmt.Errorf("pgMarkNotificationsEmailed: %w", err)
+	}
+	return nil
+}
+
+// SendEmailDigests 把未读且未发送过邮件的通知按账号汇总，每个账号发送一封摘要邮件
+func SendEmailDigests() error {
+	mailSender, ok := config.GetConfigurationString("MAIL_SENDER")
+	if !ok || mailSender == "" {
+		return fmt.Errorf("MAIL_SENDER未配置")
+	}
+	digestAccounts, err := pgSelectDigestAccounts()
+	if err != nil {
+		return fmt.Errorf("SendEmailDigests: %w", err)
+	}
+	for _, item := range digestAccounts {
+		if err := sendEmailDigest(mailSender, item); err != nil {
+			logrus.Warnln("发送通知摘要邮件出错", item.Account, err)
+		}
+	}
+	return nil
+}
+
+func sendEmailDigest(mailSender string, item *digestAccount) error {
+	accountModel, err := models.GetAccount(item.Account)
+	if err != nil {
+		return fmt.Errorf("GetAccount: %w", err)
+	}
+	// 没有邮箱的账号直接标记，避免每次重复查询
+	if accountModel == nil || accountModel.EMail == "" {
+		return pgMarkNotificationsEmailed(item)
+	}
+	notificationList, err := pgSelectDigestNotifications(item)
+	if err != nil {
+		return err
+	}
+	if len(notificationList) == 0 {
+		return nil
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteString(fmt.Sprintf("你好，%s：\n\n你有%d条未读通知。\n\n", accountModel.Nickname, item.Count))
+	for _, notification := range notificationList {
+		title := notificationKindTitles[notification.Kind]
+		if title == "" {
+			title = notification.Kind
+		}
+		builder.WriteString(fmt.Sprintf("- %s %s\n  %s\n",
+			notification.CreateTime.Local().Format("2006-01-02 15:04"), title, notification.Content))
+	}
+	if item.Count > len(notificationList) {
+		builder.WriteString(fmt.Sprintf("\n还有%d条通知未列出，请登录后查看。\n", item.Count-len(notificationList)))
+	}
+
+	subject := fmt.Sprintf("你有%d条未读通知", item.Count)
+	if err = mailer.SendMail(mailSender, subject, builder.String(), accountModel.EMail); err != nil {
+		return fmt.Errorf("SendMail: %w", err)
+	}
+	return pgMarkNotificationsEmailed(item)
+}
@@ -0,0 +1,111 @@
+package notifications
+
+import (
+	"net/http"
+	"strconv"
+
+	"portal/business"
+	"portal/models"
+
+	nemodels "github.com/pnnh/neutron/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// 通知只对登录用户开放
+func findNotificationAccount(gctx *gin.Context) (*models.AccountModel, bool) {
+	accountModel, err := business.FindAccountFromCookie(gctx)
+	if err != nil {
+		logrus.Warnln("findNotificationAccount", err)
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询账号出错c"))
+		return nil, false
+	}
+	if accountModel == nil || accountModel.IsAnonymous() {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在或匿名用户没有通知"))
+		return nil, false
+	}
+	return accountModel, true
+}
+
+func NotificationSelectHandler(gctx *gin.Context) {
+	pageInt, err := strconv.Atoi(gctx.Query("page"))
+	if err != nil {
+		pageInt = 1
+	}
+	sizeInt, err := strconv.Atoi(gctx.Query("size"))
+	if err != nil {
+		sizeInt = 20
+	}
+	accountModel, ok := findNotificationAccount(gctx)
+	if !ok {
+		return
+	}
+	unreadOnly := gctx.Query("unread") == "true"
+
+	selectResult, err := SelectNotifications(accountModel.Uid, unreadOnly, pageInt, sizeInt)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询通知出错"))
+		return
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(selectResult))
+}
+
+func NotificationUnreadHandler(gctx *gin.Context) {
+	accountModel, ok := findNotificationAccount(gctx)
+	if !ok {
+		return
+	}
+	count, err := PGCountUnreadNotifications(accountModel.Uid)
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "查询未读通知出错"))
+		return
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(map[string]any{
+		"count": count,
+	}))
+}
+
+type NotificationReadRequest struct {
+	Uids []string `json:"uids"`
+	All  bool     `json:"all"`
+}
+
+// 标记通知为已读，all为true时标记全部
+func NotificationReadHandler(gctx *gin.Context) {
+	request := &NotificationReadRequest{}
+	if err := gctx.ShouldBindJSON(request); err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithError(err))
+		return
+	}
+	if !request.All && len(request.Uids) == 0 {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("未指定要标记的通知"))
+		return
+	}
+	if len(request.Uids) > 100 {
+		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("单次最多标记100条通知"))
+		return
+	}
+	accountModel, ok := findNotificationAccount(gctx)
+	if !ok {
+		return
+	}
+
+	var changes int64
+	var err error
+	if request.All {
+		changes, err = PGMarkNotificationsRead(accountModel.Uid)
+	} else {
+		changes, err = PGMarkNotificationsRead(accountModel.Uid, request.Uids...)
+	}
+	if err != nil {
+		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "标记通知出错"))
+		return
+	}
+
+	gctx.JSON(http.StatusOK, nemodels.NECodeOk.WithData(map[string]any{
+		"changes": changes,
+	}))
+}
@@ -0,0 +1,158 @@
+package notifications
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/helpers"
+	nemodels "github.com/pnnh/neutron/models"
+	"github.com/pnnh/neutron/services/datastore"
+)
+
+// 通知类型
+const (
+	NotificationKindComment = "comment" // 自己的资源收到评论
+	NotificationKindReply   = "reply"   // 自己的评论收到回复
+	NotificationKindMention = "mention" // 在评论中被@提及
+	NotificationKindThread  = "thread"  // 参与过的讨论有新回复
+)
+
+type NotificationModel struct {
+	Uid        string    `json:"uid"`
+	Account    string    `json:"account"` // 接收通知的账号
+	Kind       string    `json:"kind"`
+	Actor      string    `json:"actor"` // 触发通知的账号
+	Resource   string    `json:"resource"`
+	Comment    string    `json:"comment"`
+	Content    string    `json:"content"` // 评论内容摘要
+	Read       bool      `json:"read"`
+	Emailed    bool      `json:"emailed"`
+	CreateTime time.Time `json:"create_time" db:"create_time"`
+	UpdateTime time.Time `json:"update_time" db:"update_time"`
+}
+
+// PGInsertNotifications 同一账号对同一评论只保留一条通知
+func PGInsertNotifications(items ...*NotificationModel) error {
+	sqlText := `insert into notifications(uid, account, kind, actor, resource, comment, content,
+        read, emailed, create_time, update_time)
+values(:uid, :account, :kind, :actor, :resource, :comment, :content, false, false, now(), now())
+on conflict (account, comment) do nothing;`
+
+	for _, model := range items {
+		if model.Uid == "" {
+			model.Uid = helpers.MustUuid()
+		}
+		sqlParams := map[string]interface{}{
+			"uid":      model.Uid,
+			"account":  model.Account,
+			"kind":     model.Kind,
+			"actor":    model.Actor,
+			"resource": model.Resource,
+			"comment":  model.Comment,
+			"content":  model.Content,
+		}
+		if _, err := datastore.NamedExec(sqlText, sqlParams); err != nil {
+			return fmt.Errorf("PGInsertNotifications: %w", err)
+		}
+	}
+	return nil
+}
+
+func SelectNotifications(account string, unreadOnly bool, page int, size int) (*nemodels.NESelectResponse, error) {
+	pagination := helpers.CalcPaginationByPage(page, size)
+	baseSqlText := ` select * from notifications where account = :account `
+	if unreadOnly {
+		baseSqlText += ` and read = false `
+	}
+
+	pageSqlText := baseSqlText + ` order by create_time desc offset :offset limit :limit; `
+	pageSqlParams := map[string]interface{}{
+		"account": account,
+		"offset":  pagination.Offset, "limit": pagination.Limit}
+	var sqlResults []*NotificationModel
+
+	rows, err := datastore.NamedQuery(pageSqlText, pageSqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	resultRange := make([]any, 0)
+	for _, item := range sqlResults {
+		resultRange = append(resultRange, item)
+	}
+
+	countSqlText := `select count(1) as count from (` + baseSqlText + `) as temp;`
+	count, err := pgCountNotifications(countSqlText, map[string]interface{}{"account": account})
+	if err != nil {
+		return nil, err
+	}
+
+	selectData := &nemodels.NESelectResponse{
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+		Count: count,
+		Range: resultRange,
+	}
+
+	return selectData, nil
+}
+
+func PGCountUnreadNotifications(account string) (int, error) {
+	sqlText := `select count(1) as count from notifications where account = :account and read = false;`
+	return pgCountNotifications(sqlText, map[string]interface{}{"account": account})
+}
+
+func pgCountNotifications(sqlText string, sqlParams map[string]interface{}) (int, error) {
+	var sqlResults []struct {
+		Count int `db:"count"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return 0, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return 0, fmt.Errorf("StructScan: %w", err)
+	}
+	if len(sqlResults) == 0 {
+		return 0, fmt.Errorf("查询通知总数有误，数据为空")
+	}
+	return sqlResults[0].Count, nil
+}
+
+// PGMarkNotificationsRead 标记通知为已读，uids为空时标记该账号的全部通知，返回实际更新的数量
+func PGMarkNotificationsRead(account string, uids ...string) (int64, error) {
+	sqlText := `update notifications set read = true, update_time = now()
+where account = :account and read = false;`
+	if len(uids) == 0 {
+		return pgExecNotifications(sqlText, map[string]interface{}{"account": account})
+	}
+
+	sqlText = `update notifications set read = true, update_time = now()
+where account = :account and uid = :uid and read = false;`
+	var changes int64
+	for _, uid := range uids {
+		affected, err := pgExecNotifications(sqlText, map[string]interface{}{"account": account, "uid": uid})
+		if err != nil {
+			return changes, err
+		}
+		changes += affected
+	}
+	return changes, nil
+}
+
+func pgExecNotifications(sqlText string, sqlParams map[string]interface{}) (int64, error) {
+	result, err := datastore.NamedExec(sqlText, sqlParams)
+	if err != nil {
+		return 0, fmt.Errorf("NamedExec: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("RowsAffected: %w", err)
+	}
+	return affected, nil
+}
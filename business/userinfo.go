@@ -1,22 +1,37 @@
 package business
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"portal/models"
+	"portal/services/confighelper"
 
 	"github.com/pnnh/neutron/config"
 	"github.com/pnnh/neutron/helpers"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
 )
 
 const AuthCookieName = "PT"
 
+// SessionTTL 登录会话的有效期，读取SESSION_TTL配置，默认30天
+// jwt过期后仍按会话记录判断是否登录，会话在有效期内被使用时自动延长
+func SessionTTL() time.Duration {
+	return confighelper.GetDuration("SESSION_TTL", 30*24*time.Hour)
+}
+
+// SessionExpireTime 新建登录会话的过期时间
+func SessionExpireTime() sql.NullTime {
+	return sql.NullTime{Time: time.Now().Add(SessionTTL()), Valid: true}
+}
+
 func FindSessionFromToken(authToken string) (*models.SessionModel, error) {
 	jwtId := ""
 	if authToken != "" {
@@ -78,10 +93,35 @@ func FindSessionFromToken(authToken string) (*models.SessionModel, error) {
 	if err != nil {
 		return nil, fmt.Errorf("查询用户会话出错: %s", err)
 	}
+	if sessionModel == nil || !sessionModel.ExpireTime.Valid {
+		return sessionModel, nil
+	}
+	// 过期的会话按未登录处理
+	if sessionModel.Expired() {
+		return nil, nil
+	}
+	RenewSessionIfNeeded(sessionModel)
 
 	return sessionModel, nil
 }
 
+// RenewSessionIfNeeded 剩余有效期不到一半时延长会话，避免每次请求都写数据库
+func RenewSessionIfNeeded(sessionModel *models.SessionModel) {
+	if !sessionModel.ExpireTime.Valid {
+		return
+	}
+	ttl := SessionTTL()
+	if time.Until(sessionModel.ExpireTime.Time) >= ttl/2 {
+		return
+	}
+	expireTime := time.Now().Add(ttl)
+	if err := models.RenewSession(sessionModel.Uid, expireTime); err != nil {
+		logrus.Warnln("延长会话有效期出错", sessionModel.Uid, err)
+		return
+	}
+	sessionModel.ExpireTime.Time = expireTime
+}
+
 func FindAccountFromCookie(gctx *gin.Context) (*models.AccountModel, error) {
 	authCookie, err := gctx.Request.Cookie(AuthCookieName)
 	if err != nil && !errors.Is(err, http.ErrNoCookie) {
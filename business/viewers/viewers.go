@@ -33,9 +33,25 @@ type MTViewerModel struct {
 	Channel string `json:"channel"`
 }
 
+// 转换为数据表结构，空字符串对应数据库中的NULL
+func (m *MTViewerModel) ToTable() *MTViewerTable {
+	table := m.MTViewerTable
+	table.Source = sql.NullString{String: m.Source, Valid: m.Source != ""}
+	table.Owner = sql.NullString{String: m.Owner, Valid: m.Owner != ""}
+	table.Channel = sql.NullString{String: m.Channel, Valid: m.Channel != ""}
+	return &table
+}
+
+// 消息队列中的浏览记录，Attempts为已经尝试写入的次数
+type MTViewerMessage struct {
+	Attempts int              `json:"attempts"`
+	Viewers  []*MTViewerModel `json:"viewers"`
+}
+
 var ErrViewerLogExists = fmt.Errorf("viewer log exists")
 
 func PGInsertViewer(viewerModels ...*MTViewerTable) (opErr error, itemErrs map[string]error) {
+	itemErrs = make(map[string]error)
 	sqlTx, err := datastore.NewTranscation()
 	if err != nil {
 		return fmt.Errorf("PGViewerNote: %w", err), nil
@@ -148,3 +164,27 @@ func updateObjectDiscover(sqlTx *datastore.SqlxTransaction, model *MTViewerTable
 	}
 	return nil
 }
+
+// PGRecalculateDiscover 按浏览记录修正文章和评论的浏览数，返回修正的行数
+// 浏览记录会按保留期清理，因此只修正小于浏览记录数的计数
+func PGRecalculateDiscover() (int64, error) {
+	sqlTexts := []string{
+		`update community.articles as a set discover = v.count
+from (select target, count(1) as count from viewers where class = 'note' group by target) as v
+where a.uid = v.target and COALESCE(a.discover, 0) < v.count;`,
+		`update comments as c set discover = v.count
+from (select target, count(1) as count from viewers where class = 'comment' group by target) as v
+where c.uid = v.target and COALESCE(c.discover, 0) < v.count;`,
+	}
+	var total int64
+	for _, sqlText := range sqlTexts {
+		result, err := datastore.NamedExec(sqlText, map[string]interface{}{})
+		if err != nil {
+			return total, fmt.Errorf("PGRecalculateDiscover: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err == nil {
+			total += affected
+		}
+	}
+	return total, nil
+}
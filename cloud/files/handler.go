@@ -17,7 +17,6 @@ import (
 	"github.com/pnnh/neutron/helpers/jsonmap"
 	nemodels "github.com/pnnh/neutron/models"
 	"github.com/pnnh/neutron/services/datastore"
-	"github.com/pnnh/neutron/services/datetime"
 	"github.com/sirupsen/logrus"
 
 	"github.com/gin-gonic/gin"
@@ -219,6 +218,16 @@ func GetRootFileInfo() *datastore.DataRow {
 }
 
 func CloudFileUpdateHandler(gctx *gin.Context) {
+	updateFile(gctx, false)
+}
+
+// CloudFileSyncHandler syncer通过接口写入同步的文件，可以设置发布状态和文章内容，仅对同步账号开放
+func CloudFileSyncHandler(gctx *gin.Context) {
+	updateFile(gctx, true)
+}
+
+// syncFields为true时接受同步的header、body、status和article字段
+func updateFile(gctx *gin.Context, syncFields bool) {
 	uid := gctx.Param("uid")
 	if uid == "" {
 		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("uid不能为空"))
@@ -246,6 +255,10 @@ func CloudFileUpdateHandler(gctx *gin.Context) {
 		gctx.JSON(http.StatusOK, nemodels.NECodeError.WithMessage("账号不存在或匿名用户不能发布笔记"))
 		return
 	}
+	if syncFields && !business.IsSyncAccount(accountModel) {
+		gctx.JSON(http.StatusOK, nemodels.NECodeUnauthorized.WithMessage("不是同步账号，不能调用同步接口"))
+		return
+	}
 
 	var parentPath string
 	if parent == RootFileUid {
@@ -270,38 +283,20 @@ func CloudFileUpdateHandler(gctx *gin.Context) {
 		return
 	}
 
-	nowTime := time.Now()
-	dataRow := datastore.NewDataRow()
-
 	// 新增记录
 	if uid == helpers.EmptyUuid() {
 		uid = helpers.MustUuid()
-		dataRow.SetString("uid", uid)
-	}
-
-	dataRow = dataRow.SetStringChain("uid", uid).SetStringChainFrom("title", jsonMap).
-		SetStringChain("header", "{}").SetStringChain("body", "{}").
-		SetNullStringChainFrom("description", jsonMap).SetNullStringChainFrom("keywords", jsonMap).
-		SetIntChain("status", 0).SetStringChainFrom("cover", jsonMap).
-		SetNullUuidStringChain("owner", accountModel.Uid).SetNullUuidStringChainFrom("channel", jsonMap).
-		SetIntChain("discover", 0).SetNullUuidStringChainFrom("partition", jsonMap).
-		SetNullTimeChain("create_time", nowTime).SetNullTimeChain("update_time", nowTime).
-		SetNullStringChainFrom("version", jsonMap).SetNullStringChainFrom("build", jsonMap).
-		SetNullStringChainFrom("url", jsonMap).SetNullStringChainFrom("branch", jsonMap).
-		SetNullStringChainFrom("commit", jsonMap).SetNullTimeChain("commit_time", datetime.NullTime).
-		SetNullStringChainFrom("relative_path", jsonMap).SetNullUuidStringChainFrom("repo_id", jsonMap).
-		SetStringChainFrom("lang", jsonMap).SetNullStringChainFrom("name", jsonMap).
-		SetNullStringChainFrom("checksum", jsonMap).SetNullStringChainFrom("syncno", jsonMap).
-		SetNullStringChainFrom("mimetype", jsonMap).SetStringChainFrom("url", jsonMap)
-	dataRow.SetString("path", parentPath+"."+uid)
-	dataRow.SetString("parent", parent)
-
+	}
+	dataRow, isArticle := NewFileDataRow(uid, accountModel.Uid, parentPath, jsonMap)
+	if syncFields {
+		dataRow, isArticle = NewSyncFileDataRow(uid, accountModel.Uid, parentPath, jsonMap)
+	}
 	if dataRow.Err != nil {
 		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(dataRow.Err, "参数错误2"))
 		return
 	}
 
-	err = pgUpdateFile(dataRow)
+	err = pgUpdateFile(dataRow, isArticle)
 	if err != nil {
 		gctx.JSON(http.StatusOK, nemodels.NEErrorResultMessage(err, "插入笔记出错"))
 		return
@@ -315,34 +310,66 @@ func CloudFileUpdateHandler(gctx *gin.Context) {
 	gctx.JSON(http.StatusOK, result)
 }
 
-func pgUpdateFile(dataRow *datastore.DataRow) error {
+func pgUpdateFile(dataRow *datastore.DataRow, isArticle bool) error {
+	return pgUpsertFile(namedExec, dataRow, isArticle)
+}
+
+// 单条写入时直接执行语句
+func namedExec(sqlText string, params map[string]any) (int64, error) {
+	result, err := datastore.NamedExec(sqlText, params)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 已存在的记录属于其他账号时upsert不会更新任何行，按出错处理
+func checkWritten(affected int64, err error) error {
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("记录已存在且属于其他账号，没有写入")
+	}
+	return nil
+}
+
+func pgUpsertFile(exec namedExecFunc, dataRow *datastore.DataRow, isArticle bool) error {
 
 	sqlText := `insert into community.files(uid, title, header, body, create_time, update_time, keywords, description, status, 
 	cover, owner, discover, version, url, 
 	lang, name, checksum, syncno, mimetype, parent, path)
 values(:uid, :title, :header, :body, :create_time, :update_time, :keywords, :description, :status, :cover, :owner, 
 	:discover, :version, :url, 
-	:lang, :name, :checksum, :syncno, :mimetype, :parent, :path);`
+	:lang, :name, :checksum, :syncno, :mimetype, :parent, :path)
+on conflict (uid)
+do update set title = excluded.title, update_time = excluded.update_time, keywords = excluded.keywords,
+	description = excluded.description, cover = excluded.cover, version = excluded.version, url = excluded.url,
+	lang = excluded.lang, name = excluded.name, checksum = excluded.checksum, syncno = excluded.syncno,
+	mimetype = excluded.mimetype, parent = excluded.parent, path = excluded.path, status = excluded.status,
+	removed_time = null
+where community.files.owner = excluded.owner
+returning uid;`
 
 	paramsMap := dataRow.InnerMap()
 
-	_, err := datastore.NamedExec(sqlText, paramsMap)
+	// 已存在的文件只有所有者可以更新，同步时文件内容变化会重新提交
+	err := checkWritten(exec(sqlText, paramsMap))
 	if err != nil {
 		return fmt.Errorf("PGConsoleInsertNote: %w", err)
 	}
 
 	mimeType := GetNullString(dataRow, "mimetype")
-	if mimeType != "" {
-		if strings.HasPrefix(mimeType, "image/") {
-			err = pgUpdateImage(dataRow)
-			if err != nil {
-				return fmt.Errorf("PGConsoleInsertNote pgUpdateImage: %w", err)
-			}
-		} else if strings.HasPrefix(mimeType, "text/") {
-			err = pgUpdateNote(dataRow)
-			if err != nil {
-				return fmt.Errorf("PGConsoleInsertNote pgUpdateImage: %w", err)
-			}
+	if strings.HasPrefix(mimeType, "image/") {
+		err = pgUpdateImage(exec, dataRow)
+		if err != nil {
+			return fmt.Errorf("PGConsoleInsertNote pgUpdateImage: %w", err)
+		}
+	}
+	if isArticle {
+		err = pgUpdateNote(exec, dataRow)
+		if err != nil {
+			return fmt.Errorf("PGConsoleInsertNote pgUpdateNote: %w", err)
 		}
 	}
 
@@ -367,16 +394,21 @@ func GetNullString(m *datastore.DataRow, key string) string {
 	return ""
 }
 
-func pgUpdateImage(dataRow *datastore.DataRow) error {
+func pgUpdateImage(exec namedExecFunc, dataRow *datastore.DataRow) error {
 
 	sqlText := `insert into community.images(uid, title, create_time, update_time, keywords, description, status, 
 	owner, discover)
 values(:uid, :title, :create_time, :update_time, :keywords, :description, :status,:owner, 
-	:discover);`
+	:discover)
+on conflict (uid)
+do update set title = excluded.title, update_time = excluded.update_time, keywords = excluded.keywords,
+	description = excluded.description, status = excluded.status
+where community.images.owner = excluded.owner
+returning uid;`
 
 	paramsMap := dataRow.InnerMap()
 
-	_, err := datastore.NamedExec(sqlText, paramsMap)
+	err := checkWritten(exec(sqlText, paramsMap))
 	if err != nil {
 		return fmt.Errorf("PGConsoleInsertNote: %w", err)
 	}
@@ -384,16 +416,24 @@ values(:uid, :title, :create_time, :update_time, :keywords, :description, :statu
 	return nil
 }
 
-func pgUpdateNote(dataRow *datastore.DataRow) error {
+func pgUpdateNote(exec namedExecFunc, dataRow *datastore.DataRow) error {
 
 	sqlText := `insert into community.articles(uid, title, header, body, create_time, update_time, keywords, description, status, 
-	cover, owner, discover)
+	cover, owner, discover, channel, lang, url, branch, commit, commit_time, relative_path, repo_id, repo_first_commit)
 values(:uid, :title, :header, :body, :create_time, :update_time, :keywords, :description, :status, :cover, :owner, 
-	:discover);`
+	:discover, :channel, :lang, :repo_url, :branch, :commit, :commit_time, :relative_path, :repo_id, :repo_first_commit)
+on conflict (uid)
+do update set title = excluded.title, header = excluded.header, body = excluded.body, update_time = excluded.update_time,
+	keywords = excluded.keywords, description = excluded.description, status = excluded.status, cover = excluded.cover,
+	channel = excluded.channel, lang = excluded.lang,
+	url = excluded.url, branch = excluded.branch, commit = excluded.commit, commit_time = excluded.commit_time,
+	relative_path = excluded.relative_path, repo_id = excluded.repo_id, repo_first_commit = excluded.repo_first_commit
+where community.articles.owner = excluded.owner
+returning uid;`
 
 	paramsMap := dataRow.InnerMap()
 
-	_, err := datastore.NamedExec(sqlText, paramsMap)
+	err := checkWritten(exec(sqlText, paramsMap))
 	if err != nil {
 		return fmt.Errorf("pgUpdateNote: %w", err)
 	}
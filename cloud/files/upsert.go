@@ -0,0 +1,115 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pnnh/neutron/helpers/jsonmap"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/pnnh/neutron/services/datetime"
+)
+
+// 执行一条带命名参数的语句，返回写入的行数，单条写入和事务中批量写入共用同一组语句
+type namedExecFunc func(sqlText string, params map[string]any) (int64, error)
+
+// NewFileDataRow 把用户提交的文件信息转换为数据库记录，jsonMap是json解码后的结果
+// 用户提交的文件都是未发布状态，不接受header、body和article，文本文件作为文章
+// 返回的isArticle表示是否同时写入文章
+func NewFileDataRow(uid, owner, parentPath string, jsonMap *jsonmap.JsonMap) (*datastore.DataRow, bool) {
+	nowTime := time.Now()
+	dataRow := datastore.NewDataRow()
+	dataRow = dataRow.SetStringChain("uid", uid).SetStringChainFrom("title", jsonMap).
+		SetStringChain("header", "{}").SetStringChain("body", "{}").
+		SetNullStringChainFrom("description", jsonMap).SetNullStringChainFrom("keywords", jsonMap).
+		SetIntChain("status", 0).SetStringChainFrom("cover", jsonMap).
+		SetNullUuidStringChain("owner", owner).SetNullUuidStringChainFrom("channel", jsonMap).
+		SetIntChain("discover", 0).SetNullUuidStringChainFrom("partition", jsonMap).
+		SetNullTimeChain("create_time", nowTime).SetNullTimeChain("update_time", nowTime).
+		SetNullStringChainFrom("version", jsonMap).SetNullStringChainFrom("build", jsonMap).
+		SetNullStringChainFrom("url", jsonMap).SetNullStringChainFrom("branch", jsonMap).
+		SetNullStringChainFrom("commit", jsonMap).SetNullTimeChain("commit_time", datetime.NullTime).
+		SetNullStringChainFrom("relative_path", jsonMap).SetNullUuidStringChainFrom("repo_id", jsonMap).
+		SetStringChainFrom("lang", jsonMap).SetNullStringChainFrom("name", jsonMap).
+		SetNullStringChainFrom("checksum", jsonMap).SetNullStringChainFrom("syncno", jsonMap).
+		SetNullStringChainFrom("mimetype", jsonMap).SetStringChainFrom("url", jsonMap).
+		SetNullStringChainFrom("repo_url", jsonMap).SetNullStringChainFrom("repo_first_commit", jsonMap)
+	// 同步git仓库时提交的commit时间
+	if commitTime, err := time.Parse(time.RFC3339, jsonMap.GetString("commit_time")); err == nil {
+		dataRow = dataRow.SetNullTimeChain("commit_time", commitTime)
+	}
+	dataRow.SetString("path", parentPath+"."+uid)
+	dataRow.SetString("parent", jsonMap.GetString("parent"))
+
+	isArticle := strings.HasPrefix(jsonMap.GetString("mimetype"), "text/")
+	return dataRow, isArticle
+}
+
+// NewSyncFileDataRow 同步的文件记录，在NewFileDataRow的基础上接受frontmatter中的header、body、status和article
+// 只用于syncer直接写数据库和同步账号调用的同步接口，jsonMap中的数字为float64
+func NewSyncFileDataRow(uid, owner, parentPath string, jsonMap *jsonmap.JsonMap) (*datastore.DataRow, bool) {
+	dataRow, isArticle := NewFileDataRow(uid, owner, parentPath, jsonMap)
+	header := jsonMap.GetString("header")
+	if header == "" {
+		header = "{}"
+	}
+	// 只接受未发布和发布两种状态，未指定时为未发布
+	status := 0
+	if value, ok := jsonMap.InnerMap()["status"].(float64); ok && value == 1 {
+		status = 1
+	}
+	dataRow.SetString("header", header)
+	dataRow.SetString("body", jsonMap.GetString("body"))
+	dataRow.SetInt("status", status)
+	// article未指定时文本文件作为文章
+	if value, ok := jsonMap.InnerMap()["article"].(float64); ok {
+		isArticle = value == 1
+	}
+	return dataRow, isArticle
+}
+
+// FileUpsert 一条待写入的文件记录
+type FileUpsert struct {
+	Row       *datastore.DataRow
+	IsArticle bool
+}
+
+// PGUpsertFiles 在一个事务中写入多条文件记录，任意一条出错时整批回滚
+func PGUpsertFiles(items []*FileUpsert) (err error) {
+	sqlTx, err := datastore.NewTranscation()
+	if err != nil {
+		return fmt.Errorf("NewTranscation: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+				err = fmt.Errorf("%w\nRollback: %v", err, rollbackErr)
+			}
+		}
+	}()
+	// 事务中没有NamedExec，按语句returning返回的行数计算写入的行数
+	txExec := func(sqlText string, params map[string]any) (int64, error) {
+		rows, err := sqlTx.NamedQuery(sqlText, params)
+		if err != nil {
+			return 0, fmt.Errorf("NamedQuery: %w", err)
+		}
+		var affected int64
+		for rows.Next() {
+			affected += 1
+		}
+		if err := rows.Err(); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("Rows: %w", err)
+		}
+		return affected, rows.Close()
+	}
+	for _, item := range items {
+		if err = pgUpsertFile(txExec, item.Row, item.IsArticle); err != nil {
+			return fmt.Errorf("PGUpsertFiles %s: %w", item.Row.GetString("uid"), err)
+		}
+	}
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
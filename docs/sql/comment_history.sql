@@ -0,0 +1,15 @@
+-- 评论编辑与删除
+alter table comments add column if not exists edited boolean not null default false;
+alter table comments add column if not exists deleted boolean not null default false;
+
+create table if not exists comment_history
+(
+    uid         uuid primary key,
+    comment     uuid        not null,
+    content     text        not null default '',
+    action      varchar(16) not null,
+    creator     uuid        not null,
+    create_time timestamptz not null default now()
+);
+
+create index if not exists comment_history_comment_idx on comment_history (comment, create_time desc);
@@ -0,0 +1,13 @@
+-- 评论Markdown渲染与@提及
+alter table comments add column if not exists content_html text not null default '';
+
+create table if not exists comment_mentions
+(
+    comment     uuid        not null,
+    account     uuid        not null,
+    name        varchar(128) not null default '',
+    create_time timestamptz not null default now(),
+    primary key (comment, account)
+);
+
+create index if not exists comment_mentions_account_idx on comment_mentions (account, create_time desc);
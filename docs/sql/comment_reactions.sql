@@ -0,0 +1,11 @@
+-- 评论反应与投票
+create table if not exists comment_reactions
+(
+    comment     uuid        not null,
+    account     uuid        not null,
+    reaction    varchar(32) not null,
+    create_time timestamptz not null default now(),
+    primary key (comment, account, reaction)
+);
+
+create index if not exists comment_reactions_account_idx on comment_reactions (account);
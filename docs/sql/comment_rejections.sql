@@ -0,0 +1,18 @@
+-- 被过滤器拒绝的评论，供人工复查
+create table if not exists comment_rejections
+(
+    uid         uuid primary key,
+    creator     uuid         not null,
+    resource    varchar(128) not null default '',
+    ipaddress   varchar(64)  not null default '',
+    content     text         not null default '',
+    filter      varchar(32)  not null,
+    reason      varchar(256) not null default '',
+    create_time timestamptz  not null default now()
+);
+
+create index if not exists comment_rejections_create_time_idx on comment_rejections (create_time desc);
+
+-- 频率限制和重复内容检测使用的索引
+create index if not exists comments_creator_create_time_idx on comments (creator, create_time desc);
+create index if not exists comments_ipaddress_create_time_idx on comments (ipaddress, create_time desc);
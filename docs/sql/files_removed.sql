@@ -0,0 +1,4 @@
+-- syncer标记已删除的源文件，SYNC_PRUNE_MODE为mark时使用
+alter table community.files add column if not exists removed_time timestamptz;
+
+create index if not exists files_syncno_idx on community.files (syncno);
@@ -0,0 +1,10 @@
+-- scheduler清理过期数据使用的索引
+create index if not exists sessions_update_time_idx on sessions (update_time);
+create index if not exists sessions_address_idx on sessions (address, update_time);
+create index if not exists viewers_update_time_idx on viewers (update_time);
+create index if not exists repo_files_syncno_idx on repo_files (repo_first_commit, branch, syncno);
+
+-- 登录会话的过期时间，已有的登录会话按最后更新时间加30天补上
+alter table sessions add column if not exists expire_time timestamptz;
+update sessions set expire_time = update_time + interval '30 days' where expire_time is null and code = '';
+create index if not exists sessions_expire_time_idx on sessions (expire_time);
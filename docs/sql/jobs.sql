@@ -0,0 +1,18 @@
+-- worker后台任务状态
+create table if not exists jobs
+(
+    uid          uuid primary key,
+    name         varchar(128) not null,
+    queue        varchar(64)  not null default 'default',
+    payload      text         not null default '',
+    status       varchar(16)  not null,
+    attempts     integer      not null default 0,
+    max_attempts integer      not null default 5,
+    run_at       timestamptz  not null default now(),
+    last_error   text         not null default '',
+    create_time  timestamptz  not null default now(),
+    update_time  timestamptz  not null default now()
+);
+
+create index if not exists jobs_status_idx on jobs (status, create_time desc);
+create index if not exists jobs_name_idx on jobs (name, create_time desc);
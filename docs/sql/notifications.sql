@@ -0,0 +1,19 @@
+-- 评论通知
+create table if not exists notifications
+(
+    uid         uuid primary key,
+    account     uuid        not null,
+    kind        varchar(32) not null,
+    actor       uuid        not null,
+    resource    varchar(128) not null default '',
+    comment     uuid        not null,
+    content     text        not null default '',
+    read        boolean     not null default false,
+    emailed     boolean     not null default false,
+    create_time timestamptz not null default now(),
+    update_time timestamptz not null default now(),
+    unique (account, comment)
+);
+
+create index if not exists notifications_account_idx on notifications (account, read, create_time desc);
+create index if not exists notifications_digest_idx on notifications (account) where read = false and emailed = false;
@@ -0,0 +1,11 @@
+-- QUEUE_DRIVER为postgres时使用的消息队列表
+create table if not exists queue_messages
+(
+    id          bigserial primary key,
+    queue       varchar(128) not null,
+    payload     bytea        not null,
+    run_at      timestamptz  not null default now(),
+    create_time timestamptz  not null default now()
+);
+
+create index if not exists queue_messages_queue_idx on queue_messages (queue, run_at, id);
@@ -0,0 +1,15 @@
+-- scheduler定时任务执行记录，同一任务的同一计划时间只会执行一次
+create table if not exists scheduler_runs
+(
+    uid           uuid primary key,
+    task          varchar(128) not null,
+    schedule_time timestamptz  not null,
+    status        varchar(16)  not null,
+    instance      varchar(256) not null default '',
+    start_time    timestamptz  not null default now(),
+    end_time      timestamptz  not null default now(),
+    error         text         not null default ''
+);
+
+create unique index if not exists scheduler_runs_task_uidx on scheduler_runs (task, schedule_time);
+create index if not exists scheduler_runs_start_idx on scheduler_runs (start_time desc);
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
+	"portal/business/comments"
+	"portal/scheduler"
+	"portal/services/queue"
 	"portal/syncer"
 	"portal/worker"
 
@@ -18,11 +24,15 @@ import (
 var (
 	configFlag  string
 	svcroleFlag string
+	dryRunFlag  bool
+	planFlag    string
 )
 
 func init() {
 	flag.StringVar(&configFlag, "config", "file://config.yaml", "config file path")
 	flag.StringVar(&svcroleFlag, "svcrole", "portal", "service role, default is portal")
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "syncer only, print the sync plan without writing anything")
+	flag.StringVar(&planFlag, "plan", "", "syncer only, write the dry-run plan to this file instead of stdout")
 }
 
 func main() {
@@ -43,21 +53,35 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// 收到SIGINT或SIGTERM后取消ctx，各模式完成手头的工作后退出，再次收到信号时直接退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		logrus.Println("收到退出信号，正在停止服务")
+	}()
+
 	switch svcroleFlag {
 	case "worker":
 		logrus.Println("portal worker mode")
-		worker.WorkerMain(configFlag)
+		worker.WorkerMain(ctx, configFlag)
 	case "syncer":
 		logrus.Println("portal syncer mode")
-		syncer.SyncerMain(configFlag)
+		syncer.SyncerMain(ctx, configFlag, dryRunFlag, planFlag)
+	case "scheduler":
+		logrus.Println("portal scheduler mode")
+		scheduler.SchedulerMain(ctx, configFlag)
 	default:
 		logrus.Println("portal main mode")
-		PortalMain()
+		PortalMain(ctx)
 	}
+	// neutron的datastore没有提供关闭接口，数据库连接随进程退出释放
+	logrus.Println("服务已停止")
 
 }
 
-func PortalMain() {
+func PortalMain(ctx context.Context) {
 
 	err := config.InitAppConfig(configFlag, "huable", "polaris", config.GetEnvName(), "portal")
 	if err != nil {
@@ -72,13 +96,18 @@ func PortalMain() {
 	if err := datastore.Init(accountDSN.(string)); err != nil {
 		logrus.Fatalln("datastore: ", err)
 	}
+	if err := queue.CheckDriver(); err != nil {
+		logrus.Fatalln("消息队列配置错误", err)
+	}
+	comments.LoadCommentBlocklist()
 
 	webServer, err := NewWebServer()
 	if err != nil {
 		logrus.Fatalln("创建web server出错", err)
 	}
 
-	if err := webServer.Start(); err != nil {
+	if err := webServer.Start(ctx); err != nil {
 		logrus.Fatalln("应用程序执行出错: %w", err)
 	}
+	comments.StopCommentViewerProducer()
 }
@@ -3,6 +3,7 @@ package repo
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/pnnh/neutron/services/datastore"
@@ -119,3 +120,50 @@ do update set commit_id=excluded.commit_id, src_path=excluded.src_path,
 	}
 	return nil
 }
+
+// PGSelectRepoFileUids 查询仓库分支下已经记录的文件uid
+func PGSelectRepoFileUids(repoFirstCommit, branch string) (map[string]struct{}, error) {
+	sqlText := `select uid::text as uid from repo_files where repo_first_commit = :repo_first_commit and branch = :branch;`
+
+	sqlParams := map[string]interface{}{
+		"repo_first_commit": repoFirstCommit,
+		"branch":            branch,
+	}
+	var sqlResults []struct {
+		Uid string `db:"uid"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	result := make(map[string]struct{}, len(sqlResults))
+	for _, item := range sqlResults {
+		result[item.Uid] = struct{}{}
+	}
+	return result, nil
+}
+
+// PGTouchRepoFiles 按提交增量同步后，把仓库分支下未删除文件的批次号更新为本次同步的批次号
+func PGTouchRepoFiles(repoFirstCommit, branch, syncno string, removedUids []string) error {
+	sqlText := `update repo_files set syncno = :syncno
+where repo_first_commit = :repo_first_commit and branch = :branch and syncno is distinct from :syncno
+	and not (uid::text = any(string_to_array(:removed_uids, ',')));`
+
+	sqlParams := map[string]interface{}{
+		"repo_first_commit": repoFirstCommit,
+		"branch":            branch,
+		"syncno":            syncno,
+		"removed_uids":      strings.Join(removedUids, ","),
+	}
+
+	_, err := datastore.NamedExec(sqlText, sqlParams)
+	if err != nil {
+		return fmt.Errorf("PGTouchRepoFiles: %w", err)
+	}
+	return nil
+}
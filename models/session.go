@@ -32,6 +32,12 @@ type SessionModel struct {
 	Address      string         `json:"address"`
 	Link         sql.NullString `json:"link" db:"link"`
 	Client       sql.NullString `json:"client" db:"client"`
+	ExpireTime   sql.NullTime   `json:"expire_time" db:"expire_time"` // 登录会话的过期时间，验证码会话为空
+}
+
+// Expired 会话是否已经过期，没有过期时间的会话不会过期
+func (m *SessionModel) Expired() bool {
+	return m.ExpireTime.Valid && m.ExpireTime.Time.Before(time.Now())
 }
 
 type SessionViewModel struct {
@@ -41,9 +47,10 @@ type SessionViewModel struct {
 func PutSession(model *SessionModel) error {
 	sqlText := `insert into sessions(uid, content, create_time, update_time, username, type, code,
 		client_id, response_type, redirect_uri, scope, state, nonce, id_token, jwt_id, access_token, open_id, company_id, 
-                     account, address, link, client) 
+                     account, address, link, client, expire_time) 
 	values(:uid, :content, :create_time, :update_time, :username, :type, :code, :client_id, :response_type, :redirect_uri,
-		:scope, :state, :nonce, :id_token, :jwt_id, :access_token, :open_id, :company_id, :account, :address, :link, :client)`
+		:scope, :state, :nonce, :id_token, :jwt_id, :access_token, :open_id, :company_id, :account, :address, :link, :client,
+		:expire_time)`
 
 	sqlParams := map[string]interface{}{"uid": model.Uid, "content": model.Content, "create_time": model.CreateTime,
 		"update_time": model.UpdateTime, "username": model.Username, "type": model.Type,
@@ -51,7 +58,8 @@ func PutSession(model *SessionModel) error {
 		"redirect_uri": model.RedirectUri, "scope": model.Scope, "state": model.State,
 		"nonce": model.Nonce, "id_token": model.IdToken, "jwt_id": model.JwtId,
 		"access_token": model.AccessToken, "open_id": model.OpenId, "company_id": model.CompanyId,
-		"account": model.Account, "address": model.Address, "link": model.Link, "client": model.Client}
+		"account": model.Account, "address": model.Address, "link": model.Link, "client": model.Client,
+		"expire_time": model.ExpireTime}
 
 	_, err := datastore.NamedExec(sqlText, sqlParams)
 	if err != nil {
@@ -81,8 +89,10 @@ func GetSessionById(uid string) (*SessionModel, error) {
 	return nil, nil
 }
 
+// GetSessionByLink 查询应用授权的会话，已过期的会话不返回，可以重新授权
 func GetSessionByLink(app, link string) (*SessionModel, error) {
-	sqlText := `select * from sessions where client = :client and link = :link;`
+	sqlText := `select * from sessions where client = :client and link = :link
+		and (expire_time is null or expire_time > now()) order by create_time desc;`
 
 	sqlParams := map[string]interface{}{
 		"link":   link,
@@ -205,6 +215,22 @@ func FindSessionByCode(clientId, code string) (*SessionModel, error) {
 
 }
 
+// RenewSession 延长登录会话的过期时间
+func RenewSession(uid string, expireTime time.Time) error {
+	sqlText := `update sessions set expire_time = :expire_time, update_time = now() where uid = :uid;`
+
+	sqlParams := map[string]interface{}{
+		"uid":         uid,
+		"expire_time": expireTime,
+	}
+
+	_, err := datastore.NamedExec(sqlText, sqlParams)
+	if err != nil {
+		return fmt.Errorf("RenewSession: %w", err)
+	}
+	return nil
+}
+
 func UpdateSessionToken(id string, accessToken, idToken, jwtId string) error {
 	sqlText := `update sessions set id_token=:id_token, access_token=:access_token, jwt_id=:jwt_id, 
 		update_time=:update_time
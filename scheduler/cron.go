@@ -0,0 +1,168 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron 标准的5段cron表达式：分 时 日 月 周
+// 每段支持 *、数字、范围 a-b、步长 */n 或 a-b/n，以及逗号分隔的列表，周日可以写作0或7
+// 另外支持 @yearly、@monthly、@weekly、@daily、@hourly
+type Cron struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronBounds struct {
+	name string
+	min  int
+	max  int
+}
+
+var (
+	minuteBounds = cronBounds{"分钟", 0, 59}
+	hourBounds   = cronBounds{"小时", 0, 23}
+	domBounds    = cronBounds{"日期", 1, 31}
+	monthBounds  = cronBounds{"月份", 1, 12}
+	dowBounds    = cronBounds{"星期", 0, 7}
+)
+
+func ParseCron(spec string) (*Cron, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron表达式需要5段: %s", spec)
+	}
+
+	cron := &Cron{}
+	var err error
+	if cron.minute, err = parseCronField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if cron.hour, err = parseCronField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if cron.dom, err = parseCronField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if cron.month, err = parseCronField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if cron.dow, err = parseCronField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+	// 7和0都表示周日
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+	cron.domStar = isStarField(fields[2])
+	cron.dowStar = isStarField(fields[4])
+	return cron, nil
+}
+
+// 与vixie cron一致，以*或?开头的日、周字段视为不限制，如 */1、*/2，此时日和周需要同时满足
+func isStarField(field string) bool {
+	return strings.HasPrefix(field, "*") || strings.HasPrefix(field, "?")
+}
+
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			value, err := strconv.Atoi(stepText)
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("%s步长有误: %s", bounds.name, part)
+			}
+			step = value
+		}
+
+		start, end := bounds.min, bounds.max
+		switch {
+		case rangeText == "*" || rangeText == "?":
+		case strings.Contains(rangeText, "-"):
+			startText, endText, _ := strings.Cut(rangeText, "-")
+			var err error
+			if start, err = strconv.Atoi(startText); err != nil {
+				return 0, fmt.Errorf("%s范围有误: %s", bounds.name, part)
+			}
+			if end, err = strconv.Atoi(endText); err != nil {
+				return 0, fmt.Errorf("%s范围有误: %s", bounds.name, part)
+			}
+		default:
+			value, err := strconv.Atoi(rangeText)
+			if err != nil {
+				return 0, fmt.Errorf("%s有误: %s", bounds.name, part)
+			}
+			start = value
+			// 5/15 表示从5开始每15个单位执行一次
+			if !hasStep {
+				end = value
+			}
+		}
+		if start < bounds.min || end > bounds.max || start > end {
+			return 0, fmt.Errorf("%s超出范围%d-%d: %s", bounds.name, bounds.min, bounds.max, part)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+// 日和周都指定时满足其一即可，其中一个为*时以另一个为准
+func (c *Cron) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next 返回t之后的下一个执行时间，精确到分钟；5年内没有匹配的时间时返回零值
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+	}
+	for _, spec := range tests {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := ParseCron(spec); err == nil {
+				t.Errorf("ParseCron(%q) 应当出错", spec)
+			}
+		})
+	}
+}
+
+func TestParseCronStar(t *testing.T) {
+	tests := []struct {
+		spec    string
+		domStar bool
+		dowStar bool
+	}{
+		{"0 0 * * *", true, true},
+		{"0 0 ? * 1", true, false},
+		{"0 0 */1 * 1", true, false},
+		{"0 0 */2 * 1", true, false},
+		{"0 0 1 * */1", false, true},
+		{"0 0 1-31 * 1", false, false},
+		{"0 0 1 * 1", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			cron, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.spec, err)
+			}
+			if cron.domStar != tt.domStar || cron.dowStar != tt.dowStar {
+				t.Errorf("ParseCron(%q) domStar=%v dowStar=%v, want %v %v",
+					tt.spec, cron.domStar, cron.dowStar, tt.domStar, tt.dowStar)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-15 是周一
+	from := time.Date(2024, 1, 15, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 1, 16, 3, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 1, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1,3,5", time.Date(2024, 1, 17, 8, 0, 0, 0, time.UTC)},
+		{"0 0 * 3 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// 日和周都指定时满足其一即可：20日或周三
+		{"0 0 20 * 3", time.Date(2024, 1, 17, 0, 0, 0, 0, time.UTC)},
+		// 日为*、?或*/n时以周为准
+		{"0 0 * * 5", time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 ? * 5", time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */1 * 5", time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC)},
+		// 奇数日且是周四，18日是偶数日
+		{"0 0 */2 * 4", time.Date(2024, 1, 25, 0, 0, 0, 0, time.UTC)},
+		// 周为*/1时以日为准
+		{"0 0 20 * */1", time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			cron, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.spec, err)
+			}
+			if got := cron.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextSequence(t *testing.T) {
+	cron, err := ParseCron("30 2 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-01-19 是周五，之后跳过周末
+	next := time.Date(2024, 1, 18, 12, 0, 0, 0, time.UTC)
+	want := []time.Time{
+		time.Date(2024, 1, 19, 2, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 22, 2, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 23, 2, 30, 0, 0, time.UTC),
+	}
+	for _, item := range want {
+		next = cron.Next(next)
+		if !next.Equal(item) {
+			t.Fatalf("Next = %v, want %v", next, item)
+		}
+	}
+}
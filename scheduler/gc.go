@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"portal/services/confighelper"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// 清理规则，whereText中的:before为按保留期计算的截止时间
+type gcRule struct {
+	name      string
+	table     string
+	whereText string
+	retention string        // 保留期配置项
+	defaults  time.Duration // 默认保留期
+}
+
+var gcRules = []*gcRule{
+	{
+		// 注册和邮箱登录验证码
+		name:      "sessions.code",
+		table:     "sessions",
+		whereText: `code <> '' and type in ('signup', 'signin') and update_time < :before`,
+		retention: "GC_SESSION_CODE_RETENTION",
+		defaults:  24 * time.Hour,
+	},
+	{
+		// 过期的登录会话，保留期从expire_time开始计算，没有过期时间的会话不清理
+		name:      "sessions",
+		table:     "sessions",
+		whereText: `code = '' and expire_time < :before`,
+		retention: "GC_SESSION_RETENTION",
+		defaults:  24 * time.Hour,
+	},
+	{
+		name:      "viewers",
+		table:     "viewers",
+		whereText: `update_time < :before`,
+		retention: "GC_VIEWER_RETENTION",
+		defaults:  180 * 24 * time.Hour,
+	},
+	{
+		// 同一仓库分支中不属于最新同步批次的文件，最新批次1小时内仍有写入时认为同步未完成，跳过该仓库
+		name:  "repo_files",
+		table: "repo_files",
+		whereText: `update_time < :before and exists (
+    select 1 from (select repo_first_commit, branch, max(syncno) as syncno, max(update_time) as update_time
+                   from repo_files group by repo_first_commit, branch) as latest
+    where latest.repo_first_commit = repo_files.repo_first_commit and latest.branch = repo_files.branch
+      and latest.syncno > repo_files.syncno and latest.update_time < now() - interval '1 hour')`,
+		retention: "GC_REPO_FILE_RETENTION",
+		defaults:  7 * 24 * time.Hour,
+	},
+}
+
+type GCResult struct {
+	Rule   string
+	Before time.Time
+	Count  int64 // dry-run时为待删除的行数
+}
+
+// RunGC 按各规则的保留期清理过期数据，dryRun为true时只统计待删除的行数
+// 每次最多删除batchSize行，避免长时间锁表
+func RunGC(ctx context.Context, dryRun bool) ([]*GCResult, error) {
+	batchSize := max(confighelper.GetInt("GC_BATCH_SIZE", 5000), 1)
+	results := make([]*GCResult, 0, len(gcRules))
+	for _, rule := range gcRules {
+		retention := confighelper.GetDuration(rule.retention, rule.defaults)
+		// 保留期配置为0或负数时不清理该规则
+		if retention <= 0 {
+			continue
+		}
+		result := &GCResult{Rule: rule.name, Before: time.Now().Add(-retention)}
+		var err error
+		if dryRun {
+			result.Count, err = rule.count(result.Before)
+		} else {
+			result.Count, err = rule.delete(ctx, result.Before, batchSize)
+		}
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("RunGC %s: %w", rule.name, err)
+		}
+	}
+	return results, nil
+}
+
+func (r *gcRule) count(before time.Time) (int64, error) {
+	sqlText := fmt.Sprintf(`select count(1) as count from %s where %s;`, r.table, r.whereText)
+
+	var sqlResults []struct {
+		Count int64 `db:"count"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, map[string]interface{}{"before": before})
+	if err != nil {
+		return 0, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return 0, fmt.Errorf("StructScan: %w", err)
+	}
+	if len(sqlResults) == 0 {
+		return 0, nil
+	}
+	return sqlResults[0].Count, nil
+}
+
+func (r *gcRule) delete(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	sqlText := fmt.Sprintf(`delete from %s where ctid in (select ctid from %s where %s limit :limit);`,
+		r.table, r.table, r.whereText)
+	sqlParams := map[string]interface{}{"before": before, "limit": batchSize}
+
+	var total int64
+	for ctx.Err() == nil {
+		result, err := datastore.NamedExec(sqlText, sqlParams)
+		if err != nil {
+			return total, fmt.Errorf("NamedExec: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("RowsAffected: %w", err)
+		}
+		total += affected
+		if affected < int64(batchSize) {
+			return total, nil
+		}
+	}
+	return total, ctx.Err()
+}
+
+// GC_DRY_RUN为true时只输出各规则待删除的行数
+func runGCTask(ctx context.Context) error {
+	dryRun := confighelper.GetBool("GC_DRY_RUN", false)
+	results, err := RunGC(ctx, dryRun)
+
+	reports := make([]string, 0, len(results))
+	for _, item := range results {
+		reports = append(reports, fmt.Sprintf("%s=%d", item.Rule, item.Count))
+	}
+	if dryRun {
+		logrus.Infoln("数据清理(dry-run)，待删除:", strings.Join(reports, " "))
+	} else {
+		logrus.Infoln("数据清理完成，已删除:", strings.Join(reports, " "))
+	}
+	return err
+}
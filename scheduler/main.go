@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"portal/services/queue"
+
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/services/datastore"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SchedulerMain 按cron配置执行定时维护任务，可以部署多个实例，同一任务同一时间只在一个实例执行
+// ctx取消后不再触发新的任务，等待执行中的任务结束后退出
+func SchedulerMain(ctx context.Context, configFlag string) {
+	err := config.InitAppConfig(configFlag, "huable", "polaris", config.GetEnvName(), "scheduler")
+	if err != nil {
+		logrus.Fatalln("初始化配置失败4", err)
+	}
+
+	accountDSN, ok := config.GetConfiguration("DATABASE")
+	if !ok || accountDSN == nil {
+		logrus.Errorln("DATABASE未配置4")
+	}
+
+	if err := datastore.Init(accountDSN.(string)); err != nil {
+		logrus.Fatalln("datastore: ", err)
+	}
+	logrus.Println("DATABASE初始化完成")
+	if err := queue.CheckDriver(); err != nil {
+		logrus.Fatalln("消息队列配置错误", err)
+	}
+
+	registerTasks()
+	scheduler, err := NewScheduler()
+	if err != nil {
+		logrus.Fatalln("初始化定时任务出错", err)
+	}
+	for _, task := range scheduler.tasks {
+		logrus.Println("已启用定时任务", task.name, task.cron.Next(time.Now().In(scheduler.location)))
+	}
+	scheduler.Run(ctx)
+	logrus.Println("scheduler已停止")
+}
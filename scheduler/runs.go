@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/helpers"
+	"github.com/pnnh/neutron/services/datastore"
+)
+
+// 执行记录状态
+const (
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+)
+
+type RunModel struct {
+	Uid          string    `json:"uid"`
+	Task         string    `json:"task"`
+	ScheduleTime time.Time `json:"schedule_time" db:"schedule_time"`
+	Status       string    `json:"status"`
+	Instance     string    `json:"instance"`
+	StartTime    time.Time `json:"start_time" db:"start_time"`
+	EndTime      time.Time `json:"end_time" db:"end_time"`
+	Error        string    `json:"error"`
+}
+
+// PGClaimRun 登记一次执行，同一个任务的同一个计划时间只能登记一次，已被其他实例登记时返回nil
+func PGClaimRun(task string, scheduleTime time.Time, instance string) (*RunModel, error) {
+	sqlText := `insert into scheduler_runs(uid, task, schedule_time, status, instance, start_time, end_time, error)
+values(:uid, :task, :schedule_time, :status, :instance, now(), now(), '')
+on conflict (task, schedule_time) do nothing
+returning *;`
+
+	sqlParams := map[string]interface{}{
+		"uid":           helpers.MustUuid(),
+		"task":          task,
+		"schedule_time": scheduleTime,
+		"status":        RunStatusRunning,
+		"instance":      instance,
+	}
+	var sqlResults []*RunModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	for _, item := range sqlResults {
+		return item, nil
+	}
+	return nil, nil
+}
+
+// PGFinishRun 记录执行结果
+func PGFinishRun(model *RunModel) error {
+	sqlText := `update scheduler_runs set status = :status, end_time = now(), error = :error where uid = :uid;`
+
+	sqlParams := map[string]interface{}{
+		"uid":    model.Uid,
+		"status": model.Status,
+		"error":  model.Error,
+	}
+
+	if _, err := datastore.NamedExec(sqlText, sqlParams); err != nil {
+		return fmt.Errorf("PGFinishRun: %w", err)
+	}
+	return nil
+}
+
+// 在事务中获取任务的advisory锁，事务结束时自动释放；其他实例持有锁时返回false
+func tryTaskLock(sqlTx *datastore.SqlxTransaction, task string) (bool, error) {
+	sqlText := `select pg_try_advisory_xact_lock(hashtext(:name)) as locked;`
+
+	var sqlResults []struct {
+		Locked bool `db:"locked"`
+	}
+
+	rows, err := sqlTx.NamedQuery(sqlText, map[string]interface{}{"name": "scheduler:" + task})
+	if err != nil {
+		return false, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return false, fmt.Errorf("StructScan: %w", err)
+	}
+	return len(sqlResults) > 0 && sqlResults[0].Locked, nil
+}
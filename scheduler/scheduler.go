@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"portal/services/confighelper"
+
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// TaskFunc 定时任务，ctx在超时或进程退出时取消
+type TaskFunc func(ctx context.Context) error
+
+var (
+	tasksLock sync.RWMutex
+	tasks     = make(map[string]TaskFunc)
+)
+
+// Register 注册定时任务，执行时间由配置项 SCHEDULE_<任务名> 指定，如 discover.recalculate 对应
+// SCHEDULE_DISCOVER_RECALCULATE，未配置的任务不执行
+func Register(name string, task TaskFunc) {
+	tasksLock.Lock()
+	defer tasksLock.Unlock()
+	tasks[name] = task
+}
+
+func scheduleConfigKey(name string) string {
+	return "SCHEDULE_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+type scheduledTask struct {
+	name string
+	cron *Cron
+	run  TaskFunc
+}
+
+// 读取各任务的cron配置，配置有误时返回错误，避免任务被静默跳过
+func loadScheduledTasks() ([]*scheduledTask, error) {
+	tasksLock.RLock()
+	defer tasksLock.RUnlock()
+
+	result := make([]*scheduledTask, 0, len(tasks))
+	for name, run := range tasks {
+		spec, ok := config.GetConfigurationString(scheduleConfigKey(name))
+		if !ok || strings.TrimSpace(spec) == "" {
+			continue
+		}
+		cron, err := ParseCron(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scheduleConfigKey(name), err)
+		}
+		result = append(result, &scheduledTask{name: name, cron: cron, run: run})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result, nil
+}
+
+type Scheduler struct {
+	tasks    []*scheduledTask
+	location *time.Location
+	timeout  time.Duration
+	instance string
+}
+
+func NewScheduler() (*Scheduler, error) {
+	scheduledTasks, err := loadScheduledTasks()
+	if err != nil {
+		return nil, fmt.Errorf("NewScheduler: %w", err)
+	}
+	location := time.Local
+	if zone, ok := config.GetConfigurationString("SCHEDULER_TIMEZONE"); ok && zone != "" {
+		location, err = time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("SCHEDULER_TIMEZONE: %w", err)
+		}
+	}
+	hostname, _ := os.Hostname()
+	return &Scheduler{
+		tasks:    scheduledTasks,
+		location: location,
+		timeout:  confighelper.GetDuration("SCHEDULER_TASK_TIMEOUT", time.Hour),
+		instance: fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+	}, nil
+}
+
+// Run 每个任务一个协程按cron等待执行，ctx取消后等待执行中的任务结束再返回
+func (s *Scheduler) Run(ctx context.Context) {
+	if len(s.tasks) == 0 {
+		logrus.Warnln("没有配置定时任务")
+	}
+	waitGroup := &sync.WaitGroup{}
+	for _, task := range s.tasks {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			s.loop(ctx, task)
+		}()
+	}
+	waitGroup.Wait()
+}
+
+// 停机期间错过的执行不会补跑
+func (s *Scheduler) loop(ctx context.Context, task *scheduledTask) {
+	for {
+		next := task.cron.Next(time.Now().In(s.location))
+		if next.IsZero() {
+			logrus.Errorln("定时任务没有下一次执行时间", task.name)
+			return
+		}
+		logrus.Debugln("定时任务下次执行", task.name, next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.execute(ctx, task, next); err != nil {
+			logrus.Errorln("定时任务执行出错", task.name, err)
+		}
+	}
+}
+
+// 多个实例同时运行时，通过advisory锁保证同一任务不会并行执行，通过执行记录保证每个计划时间只执行一次
+func (s *Scheduler) execute(ctx context.Context, task *scheduledTask, scheduleTime time.Time) (err error) {
+	sqlTx, err := datastore.NewTranscation()
+	if err != nil {
+		return fmt.Errorf("NewTranscation: %w", err)
+	}
+	// 锁只用于互斥，事务中没有写入，执行结束后直接回滚释放锁
+	defer func() {
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil && err == nil {
+			err = fmt.Errorf("Rollback: %w", rollbackErr)
+		}
+	}()
+
+	locked, err := tryTaskLock(sqlTx, task.name)
+	if err != nil {
+		return fmt.Errorf("tryTaskLock: %w", err)
+	}
+	if !locked {
+		logrus.Infoln("定时任务正在其他实例执行，跳过", task.name, scheduleTime)
+		return nil
+	}
+	runModel, err := PGClaimRun(task.name, scheduleTime.UTC(), s.instance)
+	if err != nil {
+		return fmt.Errorf("PGClaimRun: %w", err)
+	}
+	if runModel == nil {
+		logrus.Infoln("定时任务已由其他实例执行，跳过", task.name, scheduleTime)
+		return nil
+	}
+
+	logrus.Infoln("开始执行定时任务", task.name, scheduleTime)
+	startTime := time.Now()
+	taskErr := s.callTask(ctx, task)
+	runModel.Status = RunStatusSucceeded
+	if taskErr != nil {
+		runModel.Status = RunStatusFailed
+		runModel.Error = taskErr.Error()
+	}
+	logrus.Infoln("定时任务执行结束", task.name, runModel.Status, time.Since(startTime), taskErr)
+	if err = PGFinishRun(runModel); err != nil {
+		return fmt.Errorf("PGFinishRun: %w", err)
+	}
+	return nil
+}
+
+func (s *Scheduler) callTask(ctx context.Context, task *scheduledTask) (err error) {
+	taskCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("定时任务异常: %v", p)
+		}
+	}()
+	return task.run(taskCtx)
+}
@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"portal/business/notifications"
+	"portal/business/viewers"
+	"portal/syncer"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 内置的定时任务
+const (
+	TaskDiscoverRecalculate = "discover.recalculate"
+	TaskSyncerRun           = "syncer.run"
+	TaskNotificationDigest  = "notifications.digest"
+	TaskGC                  = "gc"
+)
+
+func registerTasks() {
+	Register(TaskDiscoverRecalculate, func(ctx context.Context) error {
+		count, err := viewers.PGRecalculateDiscover()
+		if err != nil {
+			return err
+		}
+		logrus.Infoln("修正浏览数", count)
+		return nil
+	})
+	Register(TaskSyncerRun, syncer.RunSync)
+	// 摘要邮件由worker发送，这里只提交任务
+	Register(TaskNotificationDigest, func(ctx context.Context) error {
+		if err := notifications.EnqueueDigest(ctx); err != nil {
+			return fmt.Errorf("提交通知摘要任务失败: %w", err)
+		}
+		return nil
+	})
+	Register(TaskGC, runGCTask)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -13,8 +15,11 @@ import (
 	"portal/business/channels"
 	"portal/business/comments"
 	"portal/business/images"
+	"portal/business/jobs"
+	"portal/business/notifications"
 	"portal/business/viewers"
 	"portal/cloud/files"
+	"portal/services/confighelper"
 
 	"github.com/pnnh/neutron/config"
 	"github.com/pnnh/neutron/services/filesystem"
@@ -57,7 +62,7 @@ func NewWebServer() (*WebServer, error) {
 
 	router.Use(cors.New(cors.Config{
 		AllowOriginFunc:  checkCorsOrigin,
-		AllowMethods:     []string{"PUT", "PATCH", "POST", "GET"},
+		AllowMethods:     []string{"PUT", "PATCH", "POST", "GET", "DELETE"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Portal-Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -116,6 +121,18 @@ func (s *WebServer) Init() error {
 
 	s.router.POST("/portal/comments", comments.CommentInsertHandler)
 	s.router.GET("/portal/comments", comments.CommentSelectHandler)
+	s.router.GET("/portal/comments/pending", comments.CommentPendingHandler)
+	s.router.POST("/portal/comments/:uid/moderate", comments.CommentModerateHandler)
+	s.router.PUT("/portal/comments/:uid", comments.CommentUpdateHandler)
+	s.router.DELETE("/portal/comments/:uid", comments.CommentDeleteHandler)
+	s.router.GET("/portal/comments/:uid/history", comments.CommentHistoryHandler)
+	s.router.POST("/portal/comments/:uid/reactions", comments.CommentReactionHandler)
+	s.router.GET("/portal/notifications", notifications.NotificationSelectHandler)
+	s.router.GET("/portal/notifications/unread", notifications.NotificationUnreadHandler)
+	s.router.POST("/portal/notifications/read", notifications.NotificationReadHandler)
+	s.router.GET("/portal/admin/jobs", jobs.JobSelectHandler)
+	s.router.GET("/portal/admin/jobs/stats", jobs.JobStatsHandler)
+	s.router.GET("/portal/admin/jobs/:uid", jobs.JobGetHandler)
 	s.router.GET("/portal/articles", articles.NoteSelectHandler)
 	s.router.GET("/portal/articles/:uid", articles.NoteGetHandler)
 	s.router.GET("/portal/articles/:uid/assets", articles.NoteAssetsSelectHandler)
@@ -138,6 +155,7 @@ func (s *WebServer) Init() error {
 
 	s.router.GET("/portal/cloud/files", files.CloudFileSelectHandler)
 	s.router.POST("/portal/cloud/files/:uid", files.CloudFileUpdateHandler)
+	s.router.POST("/portal/cloud/files/:uid/sync", files.CloudFileSyncHandler)
 	s.router.GET("/portal/cloud/files/path", files.CloudFilePathSelectHandler)
 	s.router.GET("/portal/cloud/files/desc", files.CloudFileDescHandler)
 
@@ -150,7 +168,8 @@ func (s *WebServer) Init() error {
 	return nil
 }
 
-func (s *WebServer) Start() error {
+// Start 启动HTTP服务，ctx取消后停止接收新连接，等待处理中的请求完成后返回
+func (s *WebServer) Start(ctx context.Context) error {
 	if err := s.Init(); err != nil {
 		return fmt.Errorf("初始化出错: %w", err)
 	}
@@ -168,8 +187,26 @@ func (s *WebServer) Start() error {
 		MaxHeaderBytes: 1 << 20,
 	}
 
-	logrus.Println("启动服务: " + port)
-	if err := serv.ListenAndServe(); err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		logrus.Println("启动服务: " + port)
+		serveErr <- serv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("服务出错停止: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownTimeout := confighelper.GetDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	logrus.Println("正在停止服务，等待处理中的请求完成", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := serv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("停止服务出错: %w", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("服务出错停止: %w", err)
 	}
 	return nil
@@ -0,0 +1,142 @@
+package confighelper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnnh/neutron/config"
+)
+
+// 读取整数配置项，未配置或格式错误时返回默认值
+func GetInt(key string, defaultValue int) int {
+	value, ok := config.GetConfiguration(key)
+	if !ok || value == nil {
+		return defaultValue
+	}
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case uint64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		intValue, err := strconv.Atoi(strings.TrimSpace(v))
+		if err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+// 读取小数配置项，未配置或格式错误时返回默认值
+func GetFloat(key string, defaultValue float64) float64 {
+	value, ok := config.GetConfiguration(key)
+	if !ok || value == nil {
+		return defaultValue
+	}
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// 读取布尔配置项，支持true/false以及字符串形式
+func GetBool(key string, defaultValue bool) bool {
+	value, ok := config.GetConfiguration(key)
+	if !ok || value == nil {
+		return defaultValue
+	}
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		boolValue, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// 读取时长配置项，格式同time.ParseDuration，如 30s、5m、72h，另外支持以d结尾的天数；纯数字按秒处理
+func GetDuration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := config.GetConfiguration(key)
+	if !ok || value == nil {
+		return defaultValue
+	}
+	switch v := value.(type) {
+	case int:
+		return time.Duration(v) * time.Second
+	case int64:
+		return time.Duration(v) * time.Second
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	case string:
+		duration, err := ParseDuration(v)
+		if err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("ParseDuration: %w", err)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("ParseDuration: %w", err)
+	}
+	return duration, nil
+}
+
+// 读取字符串列表配置项，支持yaml数组，也支持以逗号或换行分隔的字符串
+func GetStringList(key string) []string {
+	value, ok := config.GetConfiguration(key)
+	if !ok || value == nil {
+		return nil
+	}
+	var items []string
+	switch v := value.(type) {
+	case []string:
+		items = v
+	case []any:
+		for _, item := range v {
+			items = append(items, fmt.Sprintf("%v", item))
+		}
+	case string:
+		items = strings.FieldsFunc(v, func(r rune) bool {
+			return r == ',' || r == '\n'
+		})
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -35,17 +35,13 @@ func GitInfoGet(dirPath string) (*GitInfo, error) {
 	}
 	gitInfo.CommitId = commitId
 
-	remoteUrl, err := GitGetRemoteUrl(dirPath)
-	if err != nil {
-		return nil, fmt.Errorf("GitInfoGet: %w", err)
+	// 本地仓库可能没有配置远程仓库或跟踪分支，此时留空，远程地址会保存到数据库，去掉其中的凭据
+	if remoteUrl, err := GitGetRemoteUrl(dirPath); err == nil {
+		gitInfo.RemoteUrl = RedactUrl(remoteUrl)
 	}
-	gitInfo.RemoteUrl = remoteUrl
-
-	trackingBranch, err := GitGetTrackingBranch(dirPath)
-	if err != nil {
-		return nil, fmt.Errorf("GitInfoGet: %w", err)
+	if trackingBranch, err := GitGetTrackingBranch(dirPath); err == nil {
+		gitInfo.Tracking = trackingBranch
 	}
-	gitInfo.Tracking = trackingBranch
 
 	err = GitCheckWorkspaceClean(dirPath)
 	if err == nil {
@@ -244,3 +240,24 @@ func GitCurrentCommitId(dirPath string) (string, error) {
 	}
 	return commitId[:len(commitId)-1], nil
 }
+
+type GitFileChange struct {
+	Status string // A新增 M修改 D删除 T类型变化等，同git diff --name-status
+	Path   string // 相对于仓库根目录的路径，以/分隔
+}
+
+// 获取两次提交之间变化的文件，重命名按删除旧文件和新增新文件处理
+func GitDiffNameStatus(dirPath, fromCommit, toCommit string) ([]*GitFileChange, error) {
+	cmd := exec.Command("git", "diff", "--name-status", "--no-renames", "-z", fromCommit, toCommit)
+	cmd.Dir = dirPath
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("GitDiffNameStatus exec: %w", err)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	changes := make([]*GitFileChange, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		changes = append(changes, &GitFileChange{Status: fields[i], Path: fields[i+1]})
+	}
+	return changes, nil
+}
@@ -16,49 +16,28 @@ type GitIgnoreWarpper struct {
 }
 
 // 基于.gitignore文件的忽略规则判断某个文件是否需要忽略
-// 需要处理多级目录下的.gitignore文件，根据需要合并父子级目录下的.gitignore文件
+// 需要处理多级目录下的.gitignore文件，每个.gitignore文件中的规则相对于其所在目录匹配
 type GitIgnoreHelper struct {
 	ignoreMap    map[string]*GitIgnoreWarpper
+	loadedDirs   map[string]struct{} // 已经查找过.gitignore文件的目录
 	repoRootPath string
 }
 
 func NewGitIgnoreHelper(repoRootPath string) *GitIgnoreHelper {
 	return &GitIgnoreHelper{
 		ignoreMap:    make(map[string]*GitIgnoreWarpper),
-		repoRootPath: repoRootPath,
+		loadedDirs:   make(map[string]struct{}),
+		repoRootPath: filepath.Clean(repoRootPath),
 	}
 }
 
-func (helper *GitIgnoreHelper) findParentIgnore(ignoreFilePath string) (*GitIgnoreWarpper, error) {
-	pathDir := filepath.Dir(ignoreFilePath)
-	var length = 0
-	var bestKey = ""
-	for k, _ := range helper.ignoreMap {
-		if strings.HasPrefix(pathDir, k) && len(k) > length {
-			length = len(k)
-			bestKey = k
-		}
-	}
-	if length == 0 {
-		return nil, nil
-	}
-	return helper.ignoreMap[bestKey], nil
-}
-
 func (helper *GitIgnoreHelper) AppendGitIgnoreFile(filePath string) error {
 
 	ignoreContent, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("ReadFile: %w", err)
 	}
-	parentIgnore, err := helper.findParentIgnore(filePath)
-	if err != nil {
-		return fmt.Errorf("findParentIgnore: %w", err)
-	}
 	currentIgnoreContent := string(ignoreContent)
-	if parentIgnore != nil {
-		currentIgnoreContent = parentIgnore.ignoreContent + "\n" + currentIgnoreContent
-	}
 
 	ignoreLines := strings.Split(currentIgnoreContent, "\n")
 	compiledIgnore := ignore.CompileIgnoreLines(ignoreLines...)
@@ -68,53 +47,81 @@ func (helper *GitIgnoreHelper) AppendGitIgnoreFile(filePath string) error {
 		gitIgnore:     compiledIgnore,
 		ignoreFileDir: ignoreFileDir,
 	}
+	helper.loadedDirs[ignoreFileDir] = struct{}{}
 	return nil
 }
 
-func (helper *GitIgnoreHelper) findBestIgnore(ignoreFilePath string) (*GitIgnoreWarpper, error) {
-	pathDir := filepath.Dir(ignoreFilePath)
-	var length = 0
-	var bestKey = ""
-	for k, _ := range helper.ignoreMap {
-		if strings.HasPrefix(pathDir, k) && len(k) > length {
-			length = len(k)
-			bestKey = k
+// 读取目录下的.gitignore文件，每个目录只读取一次
+func (helper *GitIgnoreHelper) loadDir(dirPath string) error {
+	if _, ok := helper.loadedDirs[dirPath]; ok {
+		return nil
+	}
+	helper.loadedDirs[dirPath] = struct{}{}
+	ignoreFilePath := filepath.Join(dirPath, ".gitignore")
+	if _, err := os.Stat(ignoreFilePath); os.IsNotExist(err) {
+		return nil
+	}
+	return helper.AppendGitIgnoreFile(ignoreFilePath)
+}
+
+// 从仓库根目录到文件所在目录，依次查找各级目录中适用的.gitignore文件
+func (helper *GitIgnoreHelper) findIgnores(path string) ([]*GitIgnoreWarpper, error) {
+	dirs := make([]string, 0)
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if dir != helper.repoRootPath && !strings.HasPrefix(dir, helper.repoRootPath+string(os.PathSeparator)) {
+			break
+		}
+		dirs = append(dirs, dir)
+		if dir == helper.repoRootPath {
+			break
 		}
 	}
-	if length == 0 {
-		return nil, nil
+	result := make([]*GitIgnoreWarpper, 0, len(dirs))
+	for index := len(dirs) - 1; index >= 0; index-- {
+		if err := helper.loadDir(dirs[index]); err != nil {
+			return nil, fmt.Errorf("loadDir: %w", err)
+		}
+		if item, ok := helper.ignoreMap[dirs[index]]; ok {
+			result = append(result, item)
+		}
 	}
-	return helper.ignoreMap[bestKey], nil
+	return result, nil
 }
 
+// 判断文件是否被忽略，目录请使用MatchsDir，以/结尾的规则只匹配目录
 func (helper *GitIgnoreHelper) MatchsPath(path string) (bool, error) {
+	return helper.matches(path, false)
+}
+
+func (helper *GitIgnoreHelper) MatchsDir(path string) (bool, error) {
+	return helper.matches(path, true)
+}
+
+func (helper *GitIgnoreHelper) matches(path string, isDir bool) (bool, error) {
 	if path == "" {
 		return false, nil
 	}
-	if strings.Index(path, ".git") >= 0 {
-		return true, nil
-	}
-	if strings.Index(path, ".DS_Store") >= 0 {
-		return true, nil
+	for _, segment := range strings.Split(filepath.ToSlash(path), "/") {
+		if segment == ".git" || segment == ".DS_Store" {
+			return true, nil
+		}
 	}
-	//if strings.HasPrefix(path, ".") {
-	//	return true, nil
-	//}
-	//baseName := filepath.Base(path)
-	//if strings.HasPrefix(baseName, ".") {
-	//	return true, nil
-	//}
-	//if strings.Index(path, "target") >= 0 {
-	//	logrus.Println("TODO: ", path)
-	//}
-	bestIgnore, err := helper.findBestIgnore(path)
+	ignores, err := helper.findIgnores(path)
 	if err != nil {
-		return false, fmt.Errorf("findBestIgnore: %w", err)
+		return false, fmt.Errorf("findIgnores: %w", err)
 	}
-	if bestIgnore == nil {
-		return false, nil
+	for _, item := range ignores {
+		relativePath, err := filepath.Rel(item.ignoreFileDir, path)
+		if err != nil {
+			continue
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if isDir {
+			relativePath += "/"
+		}
+		if item.gitIgnore.MatchesPath(relativePath) {
+			return true, nil
+		}
 	}
-	relativePath := strings.TrimPrefix(path, bestIgnore.ignoreFileDir)
-	matchPath := bestIgnore.gitIgnore.MatchesPath(relativePath)
-	return matchPath, nil
+	return false, nil
 }
@@ -0,0 +1,85 @@
+package githelper
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// 地址中scheme://之后、@之前的用户名和密码
+var urlUserinfoRegexp = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://)[^/?#\s@]+@`)
+
+// RedactUrl 去掉文本中所有地址的用户名和密码，用于日志、错误信息和保存的仓库地址
+// git@host:path形式的地址没有密码，保持不变
+func RedactUrl(text string) string {
+	return urlUserinfoRegexp.ReplaceAllString(text, "$1")
+}
+
+// 执行git命令，出错时带上命令输出，便于排查认证和网络问题
+func gitRun(ctx context.Context, dirPath string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dirPath
+	// 没有凭据时直接失败，不等待输入用户名密码
+	cmd.Env = append(cmd.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		// 命令输出中可能带有远程地址中的凭据
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, RedactUrl(strings.TrimSpace(string(out))))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// 克隆远程仓库到指定目录，不检出文件
+func GitClone(ctx context.Context, remoteUrl, dirPath string) error {
+	if _, err := gitRun(ctx, "", "clone", "--no-checkout", remoteUrl, dirPath); err != nil {
+		return fmt.Errorf("GitClone: %w", err)
+	}
+	return nil
+}
+
+// 拉取远程仓库的分支和标签，远程地址变化时先更新地址
+func GitFetch(ctx context.Context, dirPath, remoteUrl string) error {
+	if _, err := gitRun(ctx, dirPath, "remote", "set-url", "origin", remoteUrl); err != nil {
+		return fmt.Errorf("GitFetch: %w", err)
+	}
+	if _, err := gitRun(ctx, dirPath, "fetch", "--prune", "--tags", "--force", "origin"); err != nil {
+		return fmt.Errorf("GitFetch: %w", err)
+	}
+	return nil
+}
+
+// 获取远程仓库的默认分支
+func GitRemoteDefaultBranch(ctx context.Context, dirPath string) (string, error) {
+	if _, err := gitRun(ctx, dirPath, "remote", "set-head", "origin", "--auto"); err != nil {
+		return "", fmt.Errorf("GitRemoteDefaultBranch: %w", err)
+	}
+	out, err := gitRun(ctx, dirPath, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+	if err != nil {
+		return "", fmt.Errorf("GitRemoteDefaultBranch: %w", err)
+	}
+	return strings.TrimPrefix(out, "origin/"), nil
+}
+
+// 检出远程分支的最新提交，丢弃本地修改和未跟踪的文件
+func GitCheckoutBranch(ctx context.Context, dirPath, branch string) error {
+	if _, err := gitRun(ctx, dirPath, "checkout", "--force", "-B", branch, "refs/remotes/origin/"+branch); err != nil {
+		return fmt.Errorf("GitCheckoutBranch: %w", err)
+	}
+	if _, err := gitRun(ctx, dirPath, "clean", "-fdx"); err != nil {
+		return fmt.Errorf("GitCheckoutBranch: %w", err)
+	}
+	return nil
+}
+
+// 检出标签，此时HEAD处于分离状态
+func GitCheckoutTag(ctx context.Context, dirPath, tag string) error {
+	if _, err := gitRun(ctx, dirPath, "checkout", "--force", "--detach", "refs/tags/"+tag); err != nil {
+		return fmt.Errorf("GitCheckoutTag: %w", err)
+	}
+	if _, err := gitRun(ctx, dirPath, "clean", "-fdx"); err != nil {
+		return fmt.Errorf("GitCheckoutTag: %w", err)
+	}
+	return nil
+}
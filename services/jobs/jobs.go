@@ -0,0 +1,384 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"portal/services/queue"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/helpers"
+	nemodels "github.com/pnnh/neutron/models"
+	"github.com/pnnh/neutron/services/datastore"
+)
+
+// 任务状态
+const (
+	JobStatusPending   = "pending"   // 已入队，等待执行
+	JobStatusScheduled = "scheduled" // 延时任务，未到执行时间
+	JobStatusRunning   = "running"   // 执行中
+	JobStatusRetrying  = "retrying"  // 执行失败，等待重试
+	JobStatusSucceeded = "succeeded" // 执行成功
+	JobStatusFailed    = "failed"    // 重试次数用完或没有对应的处理函数
+)
+
+const DefaultQueue = "default"
+
+const defaultMaxAttempts = 5
+
+// 每个队列对应一个key，延时任务统一放在一个延时key中，到期后移入对应队列
+const (
+	jobQueueKeyPrefix = "jobs:queue:"
+	jobDelayedKey     = "jobs:delayed"
+)
+
+func jobQueueKey(queueName string) string {
+	return jobQueueKeyPrefix + queueName
+}
+
+type JobModel struct {
+	Uid         string    `json:"uid"`
+	Name        string    `json:"name"`
+	Queue       string    `json:"queue"`
+	Payload     string    `json:"payload"` // JSON格式的任务参数
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts" db:"max_attempts"`
+	RunAt       time.Time `json:"run_at" db:"run_at"`
+	LastError   string    `json:"last_error" db:"last_error"`
+	CreateTime  time.Time `json:"create_time" db:"create_time"`
+	UpdateTime  time.Time `json:"update_time" db:"update_time"`
+}
+
+// Bind 把任务参数解析到v
+func (m *JobModel) Bind(v any) error {
+	if err := json.Unmarshal([]byte(m.Payload), v); err != nil {
+		return fmt.Errorf("解析任务参数出错: %w", err)
+	}
+	return nil
+}
+
+type EnqueueOptions struct {
+	Queue       string        // 所属队列，默认为default
+	Delay       time.Duration // 延迟执行的时间
+	RunAt       time.Time     // 指定执行时间，优先于Delay
+	MaxAttempts int           // 最多执行次数，默认为5
+}
+
+var (
+	jobQueueLock sync.Mutex
+	jobQueue     queue.Queue
+)
+
+// UseQueue 使用已经打开的消息队列，未调用时按QUEUE_DRIVER配置自动打开
+func UseQueue(q queue.Queue) {
+	jobQueueLock.Lock()
+	defer jobQueueLock.Unlock()
+	jobQueue = q
+}
+
+// 打开失败时不缓存错误，下次调用重新打开；不使用调用方的ctx，请求结束后队列仍可用
+func getJobQueue() (queue.Queue, error) {
+	jobQueueLock.Lock()
+	defer jobQueueLock.Unlock()
+	if jobQueue != nil {
+		return jobQueue, nil
+	}
+	q, err := queue.Open(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("getJobQueue: %w", err)
+	}
+	jobQueue = q
+	return jobQueue, nil
+}
+
+// QueueLengths 返回消息队列中各队列待执行的任务数和延时任务数
+func QueueLengths(ctx context.Context) (map[string]int64, int64, error) {
+	jobQueue, err := getJobQueue()
+	if err != nil {
+		return nil, 0, err
+	}
+	queueLengths := make(map[string]int64)
+	for queueName := range QueueConcurrency() {
+		length, err := jobQueue.Len(ctx, jobQueueKey(queueName))
+		if err != nil {
+			return nil, 0, fmt.Errorf("QueueLengths %s: %w", queueName, err)
+		}
+		queueLengths[queueName] = length
+	}
+	delayed, err := jobQueue.ScheduledLen(ctx, jobDelayedKey)
+	if err != nil {
+		return nil, 0, fmt.Errorf("QueueLengths: %w", err)
+	}
+	return queueLengths, delayed, nil
+}
+
+// Enqueue 按名称提交任务，payload会序列化为JSON，由worker进程中注册的同名处理函数执行
+// worker只执行JOB_QUEUES中配置的队列，提交到其它队列的任务不会执行，直接返回错误
+func Enqueue(ctx context.Context, name string, payload any, options *EnqueueOptions) (*JobModel, error) {
+	if options == nil {
+		options = &EnqueueOptions{}
+	}
+	queueName := options.Queue
+	if queueName == "" {
+		queueName = DefaultQueue
+	}
+	if _, ok := QueueConcurrency()[queueName]; !ok {
+		return nil, fmt.Errorf("Enqueue: 队列%s没有在JOB_QUEUES中配置", queueName)
+	}
+	payloadData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Enqueue Marshal: %w", err)
+	}
+	jobQueue, err := getJobQueue()
+	if err != nil {
+		return nil, fmt.Errorf("Enqueue: %w", err)
+	}
+
+	now := time.Now().UTC()
+	model := &JobModel{
+		Uid:         helpers.MustUuid(),
+		Name:        name,
+		Queue:       queueName,
+		Payload:     string(payloadData),
+		Status:      JobStatusPending,
+		MaxAttempts: options.MaxAttempts,
+		RunAt:       now,
+		CreateTime:  now,
+		UpdateTime:  now,
+	}
+	if model.MaxAttempts <= 0 {
+		model.MaxAttempts = defaultMaxAttempts
+	}
+	if !options.RunAt.IsZero() {
+		model.RunAt = options.RunAt.UTC()
+	} else if options.Delay > 0 {
+		model.RunAt = now.Add(options.Delay)
+	}
+	if model.RunAt.After(now) {
+		model.Status = JobStatusScheduled
+	}
+
+	if err = PGInsertJob(model); err != nil {
+		return nil, fmt.Errorf("Enqueue: %w", err)
+	}
+	if err = pushJob(ctx, jobQueue, model); err != nil {
+		model.Status = JobStatusFailed
+		model.LastError = err.Error()
+		if updateErr := PGUpdateJob(model); updateErr != nil {
+			err = fmt.Errorf("%w\nPGUpdateJob: %v", err, updateErr)
+		}
+		return nil, fmt.Errorf("Enqueue: %w", err)
+	}
+	return model, nil
+}
+
+// 根据执行时间放入队列或延时集合
+func pushJob(ctx context.Context, jobQueue queue.Queue, model *JobModel) error {
+	message, err := json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("pushJob Marshal: %w", err)
+	}
+	if model.RunAt.After(time.Now()) {
+		return jobQueue.Schedule(ctx, jobDelayedKey, model.RunAt, message)
+	}
+	return jobQueue.Push(ctx, jobQueueKey(model.Queue), message)
+}
+
+func PGInsertJob(model *JobModel) error {
+	sqlText := `insert into jobs(uid, name, queue, payload, status, attempts, max_attempts, run_at, last_error,
+        create_time, update_time)
+values(:uid, :name, :queue, :payload, :status, :attempts, :max_attempts, :run_at, :last_error, now(), now());`
+
+	sqlParams := map[string]interface{}{
+		"uid":          model.Uid,
+		"name":         model.Name,
+		"queue":        model.Queue,
+		"payload":      model.Payload,
+		"status":       model.Status,
+		"attempts":     model.Attempts,
+		"max_attempts": model.MaxAttempts,
+		"run_at":       model.RunAt,
+		"last_error":   model.LastError,
+	}
+
+	if _, err := datastore.NamedExec(sqlText, sqlParams); err != nil {
+		return fmt.Errorf("PGInsertJob: %w", err)
+	}
+	return nil
+}
+
+const updateJobSqlText = `update jobs set status = :status, attempts = :attempts, run_at = :run_at,
+        last_error = :last_error, update_time = now() where uid = :uid`
+
+func updateJobParams(model *JobModel) map[string]interface{} {
+	return map[string]interface{}{
+		"uid":        model.Uid,
+		"status":     model.Status,
+		"attempts":   model.Attempts,
+		"run_at":     model.RunAt,
+		"last_error": model.LastError,
+	}
+}
+
+// PGUpdateJob 更新任务的执行状态
+func PGUpdateJob(model *JobModel) error {
+	if _, err := datastore.NamedExec(updateJobSqlText+";", updateJobParams(model)); err != nil {
+		return fmt.Errorf("PGUpdateJob: %w", err)
+	}
+	return nil
+}
+
+// PGUpdateJobTx 在事务中更新任务的执行状态，事务中没有NamedExec，通过returning执行
+func PGUpdateJobTx(sqlTx *datastore.SqlxTransaction, model *JobModel) error {
+	rows, err := sqlTx.NamedQuery(updateJobSqlText+" returning uid;", updateJobParams(model))
+	if err != nil {
+		return fmt.Errorf("PGUpdateJobTx: %w", err)
+	}
+	if err = rows.Close(); err != nil {
+		return fmt.Errorf("PGUpdateJobTx Close: %w", err)
+	}
+	return nil
+}
+
+func PGGetJob(uid string) (*JobModel, error) {
+	sqlText := `select * from jobs where uid = :uid;`
+
+	sqlParams := map[string]interface{}{"uid": uid}
+	var sqlResults []*JobModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	for _, item := range sqlResults {
+		return item, nil
+	}
+	return nil, nil
+}
+
+type JobSelectParams struct {
+	Name   string
+	Queue  string
+	Status string
+}
+
+func SelectJobs(params *JobSelectParams, page int, size int) (*nemodels.NESelectResponse, error) {
+	pagination := helpers.CalcPaginationByPage(page, size)
+	baseSqlParams := map[string]interface{}{}
+
+	baseSqlText := ` select * from jobs where true `
+	if params.Name != "" {
+		baseSqlText += ` and name = :name `
+		baseSqlParams["name"] = params.Name
+	}
+	if params.Queue != "" {
+		baseSqlText += ` and queue = :queue `
+		baseSqlParams["queue"] = params.Queue
+	}
+	if params.Status != "" {
+		baseSqlText += ` and status = :status `
+		baseSqlParams["status"] = params.Status
+	}
+
+	pageSqlText := baseSqlText + ` order by create_time desc offset :offset limit :limit; `
+	pageSqlParams := map[string]interface{}{
+		"offset": pagination.Offset, "limit": pagination.Limit}
+	for k, v := range baseSqlParams {
+		pageSqlParams[k] = v
+	}
+	var sqlResults []*JobModel
+
+	rows, err := datastore.NamedQuery(pageSqlText, pageSqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	resultRange := make([]any, 0)
+	for _, item := range sqlResults {
+		resultRange = append(resultRange, item)
+	}
+
+	countSqlText := `select count(1) as count from (` + baseSqlText + `) as temp;`
+	var countSqlResults []struct {
+		Count int `db:"count"`
+	}
+
+	rows, err = datastore.NamedQuery(countSqlText, baseSqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &countSqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	if len(countSqlResults) == 0 {
+		return nil, fmt.Errorf("查询任务总数有误，数据为空")
+	}
+
+	selectData := &nemodels.NESelectResponse{
+		Page:  pagination.Page,
+		Size:  pagination.Size,
+		Count: countSqlResults[0].Count,
+		Range: resultRange,
+	}
+
+	return selectData, nil
+}
+
+type JobStatsModel struct {
+	Queue  string `json:"queue"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// 按队列和状态统计任务数量
+func PGSelectJobStats() ([]*JobStatsModel, error) {
+	sqlText := `select queue, status, count(1) as count from jobs group by queue, status order by queue, status;`
+
+	var sqlResults []*JobStatsModel
+
+	rows, err := datastore.NamedQuery(sqlText, map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+// PGClaimStaleJobs 取出update_time早于before仍处于执行中的任务，执行它们的worker已经退出
+// 同时把状态改为retrying，多个worker同时检查时每个任务只会被其中一个取出
+func PGClaimStaleJobs(before time.Time, limit int) ([]*JobModel, error) {
+	sqlText := `update jobs set status = :retrying, update_time = now()
+where uid in (select uid from jobs where status = :running and update_time < :before
+    order by update_time limit :limit for update skip locked)
+returning *;`
+
+	sqlParams := map[string]interface{}{
+		"retrying": JobStatusRetrying,
+		"running":  JobStatusRunning,
+		"before":   before,
+		"limit":    limit,
+	}
+	var sqlResults []*JobModel
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
@@ -0,0 +1,20 @@
+package jobs
+
+import "context"
+
+// 发送邮件任务，由worker进程执行
+const (
+	JobMailSend = "mail.send"
+	MailQueue   = "mail"
+)
+
+type MailSendPayload struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+}
+
+// EnqueueMail 提交发送邮件任务到mail队列
+func EnqueueMail(ctx context.Context, payload *MailSendPayload) (*JobModel, error) {
+	return Enqueue(ctx, JobMailSend, payload, &EnqueueOptions{Queue: MailQueue})
+}
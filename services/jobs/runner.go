@@ -0,0 +1,347 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"portal/services/confighelper"
+	"portal/services/queue"
+
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler 任务处理函数，返回错误时按退避策略重试
+type Handler func(ctx context.Context, job *JobModel) error
+
+var (
+	handlersLock sync.RWMutex
+	handlers     = make(map[string]Handler)
+)
+
+// Register 注册任务处理函数，同名任务重复注册时后者覆盖前者
+func Register(name string, handler Handler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers[name] = handler
+}
+
+func getHandler(name string) (Handler, bool) {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	handler, ok := handlers[name]
+	return handler, ok
+}
+
+// 延时任务的检查间隔
+const promoteInterval = time.Second
+
+// 中断任务的检查间隔
+const reapInterval = time.Minute
+
+type Runner struct {
+	queue       queue.Queue
+	concurrency map[string]int
+	timeout     time.Duration
+	retryBase   time.Duration
+	retryMax    time.Duration
+	staleAfter  time.Duration // 执行中的任务超过该时间没有更新，视为worker已经退出
+}
+
+// NewRunner concurrency为每个队列同时执行的任务数
+func NewRunner(jobQueue queue.Queue, concurrency map[string]int) *Runner {
+	runner := &Runner{
+		queue:       jobQueue,
+		concurrency: concurrency,
+		timeout:     confighelper.GetDuration("JOB_TIMEOUT", 10*time.Minute),
+		retryBase:   confighelper.GetDuration("JOB_RETRY_BASE", 10*time.Second),
+		retryMax:    confighelper.GetDuration("JOB_RETRY_MAX", time.Hour),
+	}
+	// 正常执行的任务最迟在JOB_TIMEOUT后更新状态，多留出一段时间避免误判
+	runner.staleAfter = confighelper.GetDuration("JOB_STALE_AFTER", runner.timeout+5*time.Minute)
+	return runner
+}
+
+// QueueConcurrency 读取JOB_QUEUES配置，格式为 队列:并发数，多个队列以逗号分隔，如 default:4,mail:1
+func QueueConcurrency() map[string]int {
+	concurrency := make(map[string]int)
+	for _, item := range confighelper.GetStringList("JOB_QUEUES") {
+		name, countText, _ := strings.Cut(item, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(countText))
+		if err != nil || count <= 0 {
+			count = 1
+		}
+		concurrency[name] = count
+	}
+	if len(concurrency) == 0 {
+		concurrency[DefaultQueue] = 2
+		concurrency[MailQueue] = 1
+	}
+	return concurrency
+}
+
+// Run 启动延时任务检查、中断任务检查和各队列的执行协程，ctx取消后等待所有协程退出
+func (r *Runner) Run(ctx context.Context) {
+	waitGroup := &sync.WaitGroup{}
+	waitGroup.Add(2)
+	go func() {
+		defer waitGroup.Done()
+		r.promote(ctx)
+	}()
+	go func() {
+		defer waitGroup.Done()
+		r.reap(ctx)
+	}()
+	for queueName, count := range r.concurrency {
+		logrus.Println("启动任务队列", queueName, count)
+		for index := 0; index < count; index++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				r.work(ctx, queueName)
+			}()
+		}
+	}
+	waitGroup.Wait()
+}
+
+// 把到期的延时任务移入对应队列
+func (r *Runner) promote(ctx context.Context) {
+	ticker := time.NewTicker(promoteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		messages, err := r.queue.PopDue(ctx, jobDelayedKey, time.Now(), 100)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorln("读取延时任务出错:", err)
+		}
+		for _, data := range messages {
+			model := &JobModel{}
+			if err := json.Unmarshal(data, model); err != nil {
+				logrus.Errorln("延时任务格式错误:", err, string(data))
+				continue
+			}
+			if err := r.queue.Push(context.WithoutCancel(ctx), jobQueueKey(model.Queue), data); err != nil {
+				logrus.Errorln("延时任务入队出错:", err, model.Uid)
+				continue
+			}
+			model.Status = JobStatusPending
+			if err := PGUpdateJob(model); err != nil {
+				logrus.Warnln("更新任务状态出错", model.Uid, err)
+			}
+		}
+	}
+}
+
+// 任务从队列取出后没有确认，worker崩溃时任务会一直处于执行中
+// 定期把超过staleAfter没有更新的执行中任务重新放回队列，次数用完时标记为失败
+func (r *Runner) reap(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		models, err := PGClaimStaleJobs(time.Now().Add(-r.staleAfter), 100)
+		if err != nil {
+			logrus.Errorln("查询中断的任务出错:", err)
+			continue
+		}
+		for _, model := range models {
+			model.LastError = "执行任务的worker已退出，任务未完成"
+			if model.Attempts >= model.MaxAttempts {
+				logrus.Errorln("任务执行中断", model.Name, model.Uid, model.Attempts)
+				model.Status = JobStatusFailed
+				r.saveJob(model)
+				continue
+			}
+			logrus.Warnln("任务执行中断，重新入队", model.Name, model.Uid, model.Attempts)
+			// 先保存状态再入队，避免覆盖其它worker取出后写入的执行中状态
+			model.Status = JobStatusPending
+			model.RunAt = time.Now().UTC()
+			r.saveJob(model)
+			if err := pushJob(context.WithoutCancel(ctx), r.queue, model); err != nil {
+				model.Status = JobStatusFailed
+				model.LastError = fmt.Sprintf("%s\n重新入队出错: %v", model.LastError, err)
+				r.saveJob(model)
+			}
+		}
+	}
+}
+
+// 取出后已经标记为执行中的任务
+type startedJob struct {
+	model   *JobModel
+	handler Handler
+}
+
+func (r *Runner) work(ctx context.Context, queueName string) {
+	txQueue, transactional := r.queue.(queue.TxQueue)
+	for {
+		var jobs []*startedJob
+		var err error
+		if transactional {
+			jobs, err = r.popTx(ctx, txQueue, queueName)
+		} else {
+			jobs, err = r.pop(ctx, queueName)
+		}
+		// 已经标记为执行中的任务继续执行完
+		for _, job := range jobs {
+			r.execute(ctx, job)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logrus.Errorln("读取任务队列出错:", queueName, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.retryBase):
+			}
+		}
+	}
+}
+
+// 取出任务和标记为执行中在同一个事务中提交，避免取出后来不及更新状态时任务丢失
+func (r *Runner) popTx(ctx context.Context, txQueue queue.TxQueue, queueName string) ([]*startedJob, error) {
+	var jobs []*startedJob
+	err := txQueue.PopTx(ctx, jobQueueKey(queueName), 1,
+		func(sqlTx *datastore.SqlxTransaction, messages [][]byte) error {
+			jobs = jobs[:0]
+			for _, data := range messages {
+				job, err := r.start(data, func(model *JobModel) error {
+					return PGUpdateJobTx(sqlTx, model)
+				})
+				if err != nil {
+					return err
+				}
+				if job != nil {
+					jobs = append(jobs, job)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// 不支持事务的队列取出后再更新状态，ctx取消时把来不及执行的任务放回队列
+func (r *Runner) pop(ctx context.Context, queueName string) ([]*startedJob, error) {
+	messages, err := r.queue.PopBatch(ctx, jobQueueKey(queueName), 1, 0)
+	if ctx.Err() != nil {
+		for _, data := range messages {
+			if err := r.queue.Push(context.WithoutCancel(ctx), jobQueueKey(queueName), data); err != nil {
+				logrus.Errorln("任务放回队列出错:", err, string(data))
+			}
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*startedJob, 0, len(messages))
+	for _, data := range messages {
+		job, _ := r.start(data, func(model *JobModel) error {
+			r.saveJob(model)
+			return nil
+		})
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// 解析任务并通过update标记为执行中，格式错误或没有处理函数时返回nil，不需要执行
+func (r *Runner) start(data []byte, update func(model *JobModel) error) (*startedJob, error) {
+	model := &JobModel{}
+	if err := json.Unmarshal(data, model); err != nil {
+		logrus.Errorln("任务格式错误:", err, string(data))
+		return nil, nil
+	}
+	handler, ok := getHandler(model.Name)
+	if !ok {
+		model.Status = JobStatusFailed
+		model.LastError = "未注册的任务: " + model.Name
+		return nil, update(model)
+	}
+
+	model.Attempts += 1
+	model.Status = JobStatusRunning
+	model.LastError = ""
+	if err := update(model); err != nil {
+		return nil, err
+	}
+	return &startedJob{model: model, handler: handler}, nil
+}
+
+func (r *Runner) execute(ctx context.Context, job *startedJob) {
+	model := job.model
+	jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeout)
+	err := callHandler(jobCtx, job.handler, model)
+	cancel()
+	if err == nil {
+		model.Status = JobStatusSucceeded
+		r.saveJob(model)
+		return
+	}
+
+	model.LastError = err.Error()
+	if model.Attempts >= model.MaxAttempts {
+		logrus.Errorln("任务执行失败", model.Name, model.Uid, model.Attempts, err)
+		model.Status = JobStatusFailed
+		r.saveJob(model)
+		return
+	}
+	model.Status = JobStatusRetrying
+	model.RunAt = time.Now().UTC().Add(r.backoff(model.Attempts))
+	logrus.Warnln("任务执行失败，稍后重试", model.Name, model.Uid, model.Attempts, model.RunAt, err)
+	if pushErr := pushJob(context.WithoutCancel(ctx), r.queue, model); pushErr != nil {
+		model.Status = JobStatusFailed
+		model.LastError = fmt.Sprintf("%s\n重试入队出错: %v", model.LastError, pushErr)
+	}
+	r.saveJob(model)
+}
+
+// 第n次失败后等待 retryBase * 2^(n-1)，不超过retryMax
+func (r *Runner) backoff(attempts int) time.Duration {
+	delay := r.retryBase
+	for index := 1; index < attempts && delay < r.retryMax; index++ {
+		delay *= 2
+	}
+	return min(delay, r.retryMax)
+}
+
+func (r *Runner) saveJob(model *JobModel) {
+	if err := PGUpdateJob(model); err != nil {
+		logrus.Warnln("更新任务状态出错", model.Uid, model.Status, err)
+	}
+}
+
+// 处理函数panic时按执行失败处理
+func callHandler(ctx context.Context, handler Handler, model *JobModel) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("任务执行panic: %v", p)
+		}
+	}()
+	return handler(ctx, model)
+}
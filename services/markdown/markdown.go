@@ -0,0 +1,363 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 面向评论等用户输入场景的精简Markdown渲染
+// 输入中的HTML一律转义，输出只会包含渲染器自己生成的白名单标签：
+// p br strong em del code pre blockquote ul ol li hr a span
+// 链接只允许http、https和mailto协议，并统一加上rel="nofollow ugc noopener"
+
+type Mention struct {
+	Name string `json:"name"`
+	Uid  string `json:"uid"`
+}
+
+type Options struct {
+	// 解析@提及的用户名，返回账号uid，未找到时返回false
+	ResolveMention func(name string) (string, bool)
+	// 单次渲染最多解析的提及数量，0表示使用默认值
+	MaxMentions int
+}
+
+type Result struct {
+	HTML     string
+	Mentions []*Mention
+}
+
+const defaultMaxMentions = 10
+
+var (
+	fenceRegexp       = regexp.MustCompile("^\\s{0,3}(```|~~~)\\s*([A-Za-z0-9_+\\-]*)\\s*$")
+	headingRegexp     = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	ruleRegexp        = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	unorderedRegexp   = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+	orderedRegexp     = regexp.MustCompile(`^\s{0,3}\d{1,9}[.)]\s+(.*)$`)
+	quoteRegexp       = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	linkRegexp        = regexp.MustCompile(`\[([^\[\]]*)\]\(\s*<?([^()\s<>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	autoLinkRegexp    = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'\x00` + "`" + `]+`)
+	strongRegexp      = regexp.MustCompile(`\*\*([^*]+?)\*\*|__([^_]+?)__`)
+	emRegexp          = regexp.MustCompile(`\*([^*\s][^*]*?)\*|(^|[^\w])_([^_\s][^_]*?)_([^\w]|$)`)
+	delRegexp         = regexp.MustCompile(`~~([^~]+?)~~`)
+	mentionRegexp     = regexp.MustCompile(`(^|[^\w@.\-])@([A-Za-z0-9_](?:[A-Za-z0-9_.\-]*[A-Za-z0-9_])?)`)
+	placeholderRegexp = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+type renderer struct {
+	options      *Options
+	placeholders []string
+	mentions     []*Mention
+	mentionMap   map[string]*Mention
+}
+
+func Render(source string, options *Options) *Result {
+	if options == nil {
+		options = &Options{}
+	}
+	r := &renderer{
+		options:    options,
+		mentionMap: make(map[string]*Mention),
+	}
+	source = strings.ReplaceAll(source, "\x00", "")
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	lines := strings.Split(source, "\n")
+
+	return &Result{
+		HTML:     r.renderBlocks(lines),
+		Mentions: r.mentions,
+	}
+}
+
+func (r *renderer) renderBlocks(lines []string) string {
+	builder := &strings.Builder{}
+	paragraph := make([]string, 0)
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		inlines := make([]string, 0, len(paragraph))
+		for _, line := range paragraph {
+			inlines = append(inlines, r.renderInline(strings.TrimSpace(line)))
+		}
+		builder.WriteString("<p>" + strings.Join(inlines, "<br>") + "</p>")
+		paragraph = paragraph[:0]
+	}
+
+	for index := 0; index < len(lines); index++ {
+		line := lines[index]
+		if strings.TrimSpace(line) == "" {
+			flushParagraph()
+			continue
+		}
+		if match := fenceRegexp.FindStringSubmatch(line); match != nil {
+			flushParagraph()
+			codeLines := make([]string, 0)
+			index++
+			for ; index < len(lines); index++ {
+				if strings.TrimSpace(lines[index]) == match[1] {
+					break
+				}
+				codeLines = append(codeLines, lines[index])
+			}
+			codeClass := ""
+			if match[2] != "" {
+				codeClass = fmt.Sprintf(` class="language-%s"`, html.EscapeString(match[2]))
+			}
+			builder.WriteString(fmt.Sprintf("<pre><code%s>%s</code></pre>", codeClass,
+				html.EscapeString(strings.Join(codeLines, "\n"))))
+			continue
+		}
+		if ruleRegexp.MatchString(line) {
+			flushParagraph()
+			builder.WriteString("<hr>")
+			continue
+		}
+		if match := headingRegexp.FindStringSubmatch(line); match != nil {
+			// 评论中不使用标题层级，按加粗段落处理
+			flushParagraph()
+			builder.WriteString("<p><strong>" + r.renderInline(match[1]) + "</strong></p>")
+			continue
+		}
+		if quoteRegexp.MatchString(line) {
+			flushParagraph()
+			quoteLines := make([]string, 0)
+			for ; index < len(lines); index++ {
+				match := quoteRegexp.FindStringSubmatch(lines[index])
+				if match == nil {
+					break
+				}
+				quoteLines = append(quoteLines, match[1])
+			}
+			index--
+			builder.WriteString("<blockquote>" + r.renderBlocks(quoteLines) + "</blockquote>")
+			continue
+		}
+		if listRegexp, listTag := r.matchList(line); listRegexp != nil {
+			flushParagraph()
+			builder.WriteString("<" + listTag + ">")
+			for ; index < len(lines); index++ {
+				match := listRegexp.FindStringSubmatch(lines[index])
+				if match == nil {
+					break
+				}
+				builder.WriteString("<li>" + r.renderInline(strings.TrimSpace(match[1])) + "</li>")
+			}
+			index--
+			builder.WriteString("</" + listTag + ">")
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flushParagraph()
+
+	return builder.String()
+}
+
+func (r *renderer) matchList(line string) (*regexp.Regexp, string) {
+	if unorderedRegexp.MatchString(line) {
+		return unorderedRegexp, "ul"
+	}
+	if orderedRegexp.MatchString(line) {
+		return orderedRegexp, "ol"
+	}
+	return nil, ""
+}
+
+// 先把代码和链接替换为占位符，转义剩余文本后再处理强调和提及，最后还原占位符
+func (r *renderer) renderInline(text string) string {
+	text = r.replaceCodeAndLinks(text)
+	text = autoLinkRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		trimmed := strings.TrimRight(match, ".,;:!?)")
+		suffix := match[len(trimmed):]
+		href, ok := safeUrl(trimmed)
+		if !ok {
+			return match
+		}
+		return r.placeholder(linkTag(href, html.EscapeString(trimmed))) + suffix
+	})
+
+	escaped := r.renderEmphasis(html.EscapeString(text))
+	escaped = r.renderMentions(escaped)
+
+	return r.restorePlaceholders(escaped)
+}
+
+// 从左到右扫描行内代码和链接，先出现的优先，链接地址中的反引号不作为行内代码
+// 链接文字中开始的行内代码越过了]时按行内代码处理
+func (r *renderer) replaceCodeAndLinks(text string) string {
+	builder := &strings.Builder{}
+	for index := 0; index < len(text); {
+		switch text[index] {
+		case '`':
+			code, length := matchCodeSpan(text[index:])
+			if length > 0 {
+				builder.WriteString(r.placeholder("<code>" + html.EscapeString(code) + "</code>"))
+				index += length
+				continue
+			}
+			// 没有对应的结束反引号时，整段反引号按普通文本处理
+			runLength := backtickRun(text[index:])
+			builder.WriteString(text[index : index+runLength])
+			index += runLength
+			continue
+		case '[':
+			if parts := linkRegexp.FindStringSubmatchIndex(text[index:]); parts != nil && parts[0] == 0 &&
+				!codeSpanCrossesLabel(text[index:], parts[3]) {
+				label := text[index+parts[2] : index+parts[3]]
+				target := text[index+parts[4] : index+parts[5]]
+				builder.WriteString(r.renderLink(label, target))
+				index += parts[1]
+				continue
+			}
+		}
+		builder.WriteByte(text[index])
+		index++
+	}
+	return builder.String()
+}
+
+func (r *renderer) renderLink(label, target string) string {
+	href, ok := safeUrl(target)
+	labelHtml := r.renderEmphasis(html.EscapeString(r.replaceCodeAndLinks(label)))
+	if !ok {
+		return r.placeholder(labelHtml)
+	}
+	if labelHtml == "" {
+		labelHtml = html.EscapeString(target)
+	}
+	return r.placeholder(linkTag(href, labelHtml))
+}
+
+func backtickRun(text string) int {
+	length := 0
+	for length < len(text) && text[length] == '`' {
+		length++
+	}
+	return length
+}
+
+// 匹配以text开头的行内代码，结束的反引号数量必须与开始的相同，返回代码内容和整段长度
+func matchCodeSpan(text string) (string, int) {
+	runLength := backtickRun(text)
+	for index := runLength; index < len(text); {
+		if text[index] != '`' {
+			index++
+			continue
+		}
+		closeLength := backtickRun(text[index:])
+		if closeLength == runLength {
+			code := strings.TrimSpace(text[runLength:index])
+			if code == "" {
+				return "", 0
+			}
+			return code, index + closeLength
+		}
+		index += closeLength
+	}
+	return "", 0
+}
+
+// 链接文字中的行内代码在]之后才结束时，[...]不是链接
+func codeSpanCrossesLabel(text string, labelEnd int) bool {
+	for index := 1; index < labelEnd; {
+		if text[index] != '`' {
+			index++
+			continue
+		}
+		_, length := matchCodeSpan(text[index:])
+		if length == 0 {
+			index += backtickRun(text[index:])
+			continue
+		}
+		if index+length > labelEnd {
+			return true
+		}
+		index += length
+	}
+	return false
+}
+
+// 链接文字中可能包含行内代码的占位符，因此递归还原
+func (r *renderer) restorePlaceholders(text string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		parts := placeholderRegexp.FindStringSubmatch(match)
+		index, err := strconv.Atoi(parts[1])
+		if err != nil || index >= len(r.placeholders) {
+			return ""
+		}
+		return r.restorePlaceholders(r.placeholders[index])
+	})
+}
+
+func (r *renderer) renderEmphasis(text string) string {
+	text = strongRegexp.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = delRegexp.ReplaceAllString(text, "<del>$1</del>")
+	text = emRegexp.ReplaceAllString(text, "$2<em>$1$3</em>$4")
+	return text
+}
+
+func (r *renderer) renderMentions(text string) string {
+	if r.options.ResolveMention == nil {
+		return text
+	}
+	maxMentions := r.options.MaxMentions
+	if maxMentions <= 0 {
+		maxMentions = defaultMaxMentions
+	}
+	return mentionRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		parts := mentionRegexp.FindStringSubmatch(match)
+		prefix, name := parts[1], parts[2]
+		mention, ok := r.mentionMap[name]
+		if !ok {
+			if len(r.mentionMap) >= maxMentions {
+				return match
+			}
+			uid, found := r.options.ResolveMention(name)
+			mention = nil
+			if found {
+				mention = &Mention{Name: name, Uid: uid}
+				r.mentions = append(r.mentions, mention)
+			}
+			r.mentionMap[name] = mention
+		}
+		if mention == nil {
+			return match
+		}
+		return prefix + fmt.Sprintf(`<span class="mention" data-uid="%s">@%s</span>`,
+			html.EscapeString(mention.Uid), name)
+	})
+}
+
+func (r *renderer) placeholder(content string) string {
+	r.placeholders = append(r.placeholders, content)
+	return fmt.Sprintf("\x00%d\x00", len(r.placeholders)-1)
+}
+
+func linkTag(href string, label string) string {
+	return fmt.Sprintf(`<a href="%s" rel="nofollow ugc noopener">%s</a>`, html.EscapeString(href), label)
+}
+
+// 只允许http、https和mailto链接
+func safeUrl(rawUrl string) (string, bool) {
+	parsedUrl, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(parsedUrl.Scheme) {
+	case "http", "https":
+		if parsedUrl.Host == "" {
+			return "", false
+		}
+	case "mailto":
+	default:
+		return "", false
+	}
+	return parsedUrl.String(), true
+}
@@ -0,0 +1,105 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func resolveTestMention(name string) (string, bool) {
+	return "uid-" + name, name == "alice"
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"code span before link", "`x` [y](http://a.b/`q`)",
+			`<p><code>x</code> <a href="http://a.b/%60q%60" rel="nofollow ugc noopener">y</a></p>`},
+		{"code span in link label", "[`code`](https://a.b)",
+			`<p><a href="https://a.b" rel="nofollow ugc noopener"><code>code</code></a></p>`},
+		{"link inside code span", "`[a](http://x.y)`", `<p><code>[a](http://x.y)</code></p>`},
+		{"code span crossing label", "[foo`](/uri)`", `<p>[foo<code>](/uri)</code></p>`},
+		{"code span with backtick", "``a ` b``", "<p><code>a ` b</code></p>"},
+		{"unclosed backtick", "unclosed ` tick", "<p>unclosed ` tick</p>"},
+		{"html in code span", "`<b>`", `<p><code>&lt;b&gt;</code></p>`},
+		{"emphasis", "**b** *i* ~~d~~", `<p><strong>b</strong> <em>i</em> <del>d</del></p>`},
+		{"mailto link", "[m](mailto:a@b.c)", `<p><a href="mailto:a@b.c" rel="nofollow ugc noopener">m</a></p>`},
+		{"relative link", "[r](/relative)", `<p>r</p>`},
+		{"link without host", "[e](https:///nohost)", `<p>e</p>`},
+		{"autolink", "see https://a.b/c.", `<p>see <a href="https://a.b/c" rel="nofollow ugc noopener">https://a.b/c</a>.</p>`},
+		{"fenced code", "```\n<script>\n```", `<pre><code>&lt;script&gt;</code></pre>`},
+		{"mentions", "hi @alice and @bob, mail a@b.c",
+			`<p>hi <span class="mention" data-uid="uid-alice">@alice</span> and @bob, mail a@b.c</p>`},
+		{"mention in code span", "@alice `@alice`",
+			`<p><span class="mention" data-uid="uid-alice">@alice</span> <code>@alice</code></p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.source, &Options{ResolveMention: resolveTestMention}).HTML
+			if got != tt.want {
+				t.Errorf("Render(%q)\n got: %s\nwant: %s", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderXSS(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"javascript scheme", "[a](javascript:void0)", `<p>a</p>`},
+		{"mixed case scheme", "[a](JaVaScRiPt:void0)", `<p>a</p>`},
+		{"entity encoded scheme", "[a](&#106;avascript:void0)", `<p>a</p>`},
+		{"entity encoded tab", "[a](java&#x09;script:void0)", `<p>a</p>`},
+		{"percent encoded scheme", "[a](%6Aavascript:void0)", `<p>a</p>`},
+		{"data scheme", "[a](data:text/html;base64,PHNjcmlwdD4=)", `<p>a</p>`},
+		{"vbscript scheme", "[a](vbscript:msgbox)", `<p>a</p>`},
+		{"raw script", "<script>alert(1)</script>", `<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`},
+		{"raw img", "<img src=x onerror=alert(1)>", `<p>&lt;img src=x onerror=alert(1)&gt;</p>`},
+		{"attribute injection in href", `[a](http://a.b/"onmouseover="x)`,
+			`<p><a href="http://a.b/%22onmouseover=%22x" rel="nofollow ugc noopener">a</a></p>`},
+		{"attribute injection in label", `[a" onclick="x](http://a.b)`,
+			`<p><a href="http://a.b" rel="nofollow ugc noopener">a&#34; onclick=&#34;x</a></p>`},
+		{"attribute injection in autolink", `http://a.b/"onmouseover=alert(1)`,
+			`<p><a href="http://a.b/" rel="nofollow ugc noopener">http://a.b/</a>&#34;onmouseover=alert(1)</p>`},
+		{"html in link label", "[<b>x</b>](http://a.b)",
+			`<p><a href="http://a.b" rel="nofollow ugc noopener">&lt;b&gt;x&lt;/b&gt;</a></p>`},
+		{"fence language injection", "```js\"onload=\"x\ncode\n```", ""},
+		{"nul placeholder forgery", "\x000\x00<script>", `<p>0&lt;script&gt;</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.source, &Options{ResolveMention: resolveTestMention}).HTML
+			if tt.want != "" && got != tt.want {
+				t.Errorf("Render(%q)\n got: %s\nwant: %s", tt.source, got, tt.want)
+			}
+			lower := strings.ToLower(got)
+			for _, unsafe := range []string{"<script", "<img", "javascript:", "vbscript:", "data:", `" on`, `"on`} {
+				if strings.Contains(lower, unsafe) {
+					t.Errorf("Render(%q) contains %q: %s", tt.source, unsafe, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderMentionLimit(t *testing.T) {
+	calls := 0
+	result := Render("@alice @bob @carol", &Options{
+		MaxMentions: 2,
+		ResolveMention: func(name string) (string, bool) {
+			calls++
+			return "uid-" + name, true
+		},
+	})
+	if calls != 2 || len(result.Mentions) != 2 {
+		t.Errorf("calls = %d, mentions = %d, want 2 and 2", calls, len(result.Mentions))
+	}
+	if !strings.HasSuffix(result.HTML, " @carol</p>") {
+		t.Errorf("HTML = %s", result.HTML)
+	}
+}
@@ -0,0 +1,146 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryDelayed struct {
+	runAt   time.Time
+	message []byte
+}
+
+// MemoryQueue 进程内的消息队列，进程退出后消息丢失，用于测试，不能通过QUEUE_DRIVER配置
+type MemoryQueue struct {
+	lock    sync.Mutex
+	lists   map[string][][]byte
+	delayed map[string][]*memoryDelayed
+	changed chan struct{} // 有新消息时关闭并替换，用于唤醒等待中的读取
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		lists:   make(map[string][][]byte),
+		delayed: make(map[string][]*memoryDelayed),
+		changed: make(chan struct{}),
+	}
+}
+
+func (q *MemoryQueue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *MemoryQueue) Push(ctx context.Context, key string, messages ...[]byte) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, item := range messages {
+		q.lists[key] = append(q.lists[key], append([]byte(nil), item...))
+	}
+	q.notifyLocked()
+	return nil
+}
+
+// 取出最多maxCount条消息，同时返回用于等待新消息的通道
+func (q *MemoryQueue) pop(key string, maxCount int) ([][]byte, chan struct{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	list := q.lists[key]
+	count := min(maxCount, len(list))
+	messages := list[:count:count]
+	if count == len(list) {
+		delete(q.lists, key)
+	} else {
+		q.lists[key] = list[count:]
+	}
+	return messages, q.changed
+}
+
+func (q *MemoryQueue) PopBatch(ctx context.Context, key string, maxCount int,
+	maxWait time.Duration) ([][]byte, error) {
+	messages := make([][]byte, 0, maxCount)
+
+	for len(messages) == 0 {
+		values, changed := q.pop(key, maxCount)
+		messages = append(messages, values...)
+		if len(messages) > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return messages, ctx.Err()
+		case <-changed:
+		}
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	for len(messages) < maxCount {
+		values, changed := q.pop(key, maxCount-len(messages))
+		messages = append(messages, values...)
+		if len(messages) >= maxCount {
+			break
+		}
+		if len(values) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return messages, ctx.Err()
+		case <-timer.C:
+			return messages, nil
+		case <-changed:
+		}
+	}
+	return messages, nil
+}
+
+func (q *MemoryQueue) Len(ctx context.Context, key string) (int64, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return int64(len(q.lists[key])), nil
+}
+
+func (q *MemoryQueue) Schedule(ctx context.Context, key string, runAt time.Time, message []byte) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	items := append(q.delayed[key], &memoryDelayed{runAt: runAt, message: append([]byte(nil), message...)})
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].runAt.Before(items[j].runAt)
+	})
+	q.delayed[key] = items
+	return nil
+}
+
+func (q *MemoryQueue) PopDue(ctx context.Context, key string, now time.Time, limit int) ([][]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	items := q.delayed[key]
+	messages := make([][]byte, 0)
+	for len(items) > 0 && len(messages) < limit && !items[0].runAt.After(now) {
+		messages = append(messages, items[0].message)
+		items = items[1:]
+	}
+	if len(items) == 0 {
+		delete(q.delayed, key)
+	} else {
+		q.delayed[key] = items
+	}
+	return messages, nil
+}
+
+func (q *MemoryQueue) ScheduledLen(ctx context.Context, key string) (int64, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return int64(len(q.delayed[key])), nil
+}
+
+// Close 进程内共享的队列不需要关闭
+func (q *MemoryQueue) Close() error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func messageStrings(messages [][]byte) []string {
+	values := make([]string, 0, len(messages))
+	for _, item := range messages {
+		values = append(values, string(item))
+	}
+	return values
+}
+
+func TestMemoryQueuePopBatch(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	if err := q.Push(ctx, "a", []byte("1"), []byte("2"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(ctx, "b", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if length, _ := q.Len(ctx, "a"); length != 3 {
+		t.Errorf("Len = %d, want 3", length)
+	}
+
+	// 先进先出，最多返回maxCount条
+	messages, err := q.PopBatch(ctx, "a", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageStrings(messages); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("PopBatch = %v, want [1 2]", got)
+	}
+	// 不足maxCount条时等待maxWait后返回已读取的消息
+	messages, err = q.PopBatch(ctx, "a", 5, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageStrings(messages); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("PopBatch = %v, want [3]", got)
+	}
+	if length, _ := q.Len(ctx, "a"); length != 0 {
+		t.Errorf("Len = %d, want 0", length)
+	}
+	// 不同key的消息互不影响
+	if length, _ := q.Len(ctx, "b"); length != 1 {
+		t.Errorf("Len(b) = %d, want 1", length)
+	}
+}
+
+func TestMemoryQueuePopBatchCopiesMessage(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	data := []byte("1")
+	if err := q.Push(ctx, "a", data); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = '2'
+	messages, err := q.PopBatch(ctx, "a", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageStrings(messages); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("PopBatch = %v, want [1]", got)
+	}
+}
+
+func TestMemoryQueuePopBatchWaits(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = q.Push(ctx, "a", []byte("1"))
+	}()
+	// 没有消息时阻塞等待第一条消息
+	messages, err := q.PopBatch(ctx, "a", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageStrings(messages); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("PopBatch = %v, want [1]", got)
+	}
+}
+
+func TestMemoryQueuePopBatchCancel(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	messages, err := q.PopBatch(ctx, "a", 1, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("PopBatch err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(messages) != 0 {
+		t.Errorf("PopBatch = %v, want empty", messageStrings(messages))
+	}
+}
+
+func TestMemoryQueuePopDue(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	items := []struct {
+		runAt   time.Time
+		message string
+	}{
+		{now.Add(time.Minute), "later"},
+		{now.Add(-time.Minute), "due2"},
+		{now.Add(-time.Hour), "due1"},
+		{now, "due3"},
+	}
+	for _, item := range items {
+		if err := q.Schedule(ctx, "delayed", item.runAt, []byte(item.message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if length, _ := q.ScheduledLen(ctx, "delayed"); length != 4 {
+		t.Errorf("ScheduledLen = %d, want 4", length)
+	}
+	// 按执行时间取出已到期的消息，最多limit条
+	messages, err := q.PopDue(ctx, "delayed", now, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageStrings(messages); !reflect.DeepEqual(got, []string{"due1", "due2"}) {
+		t.Errorf("PopDue = %v, want [due1 due2]", got)
+	}
+	messages, err = q.PopDue(ctx, "delayed", now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageStrings(messages); !reflect.DeepEqual(got, []string{"due3"}) {
+		t.Errorf("PopDue = %v, want [due3]", got)
+	}
+	// 延时消息不能通过PopBatch取出
+	if length, _ := q.Len(ctx, "delayed"); length != 0 {
+		t.Errorf("Len = %d, want 0", length)
+	}
+	messages, err = q.PopDue(ctx, "delayed", now.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageStrings(messages); !reflect.DeepEqual(got, []string{"later"}) {
+		t.Errorf("PopDue = %v, want [later]", got)
+	}
+	if length, _ := q.ScheduledLen(ctx, "delayed"); length != 0 {
+		t.Errorf("ScheduledLen = %d, want 0", length)
+	}
+}
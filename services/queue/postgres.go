@@ -0,0 +1,208 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"portal/services/confighelper"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/services/datastore"
+)
+
+// PostgresQueue 基于queue_messages表的消息队列，通过for update skip locked保证每条消息只被取出一次
+// 没有消息时按QUEUE_POLL_INTERVAL轮询
+type PostgresQueue struct {
+	pollInterval time.Duration
+}
+
+func NewPostgresQueue() *PostgresQueue {
+	return &PostgresQueue{
+		pollInterval: confighelper.GetDuration("QUEUE_POLL_INTERVAL", time.Second),
+	}
+}
+
+type queueMessageRow struct {
+	Id      int64  `db:"id"`
+	Payload []byte `db:"payload"`
+}
+
+func (q *PostgresQueue) Push(ctx context.Context, key string, messages ...[]byte) error {
+	sqlText := `insert into queue_messages(queue, payload, run_at, create_time) values(:queue, :payload, now(), now());`
+	for _, item := range messages {
+		sqlParams := map[string]interface{}{"queue": key, "payload": item}
+		if _, err := datastore.NamedExec(sqlText, sqlParams); err != nil {
+			return fmt.Errorf("PostgresQueue Push: %w", err)
+		}
+	}
+	return nil
+}
+
+type namedQueryFunc func(sqlText string, sqlParams any) (*sqlx.Rows, error)
+
+// 删除并返回已到期的消息，按写入顺序排列
+func (q *PostgresQueue) pop(key string, until time.Time, limit int) ([][]byte, error) {
+	return popMessages(datastore.NamedQuery, key, until, limit)
+}
+
+// query为事务的NamedQuery时，事务回滚后消息仍在队列中
+func popMessages(query namedQueryFunc, key string, until time.Time, limit int) ([][]byte, error) {
+	sqlText := `delete from queue_messages where id in (
+    select id from queue_messages where queue = :queue and run_at <= :until
+    order by run_at, id limit :limit for update skip locked)
+returning id, payload;`
+
+	sqlParams := map[string]interface{}{"queue": key, "until": until, "limit": limit}
+	var sqlResults []*queueMessageRow
+
+	rows, err := query(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	sort.Slice(sqlResults, func(i, j int) bool {
+		return sqlResults[i].Id < sqlResults[j].Id
+	})
+	messages := make([][]byte, 0, len(sqlResults))
+	for _, item := range sqlResults {
+		messages = append(messages, item.Payload)
+	}
+	return messages, nil
+}
+
+func (q *PostgresQueue) PopBatch(ctx context.Context, key string, maxCount int,
+	maxWait time.Duration) ([][]byte, error) {
+	messages := make([][]byte, 0, maxCount)
+	var deadline time.Time
+	for {
+		values, err := q.pop(key, time.Now(), maxCount-len(messages))
+		if err != nil {
+			return messages, fmt.Errorf("PostgresQueue PopBatch: %w", err)
+		}
+		messages = append(messages, values...)
+		if len(messages) >= maxCount {
+			return messages, nil
+		}
+		if len(messages) > 0 {
+			if deadline.IsZero() {
+				deadline = time.Now().Add(maxWait)
+			}
+			if len(values) > 0 && maxWait > 0 {
+				continue
+			}
+			if !time.Now().Before(deadline) {
+				return messages, nil
+			}
+		}
+
+		wait := q.pollInterval
+		if !deadline.IsZero() {
+			wait = min(wait, time.Until(deadline))
+		}
+		select {
+		case <-ctx.Done():
+			return messages, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (q *PostgresQueue) PopTx(ctx context.Context, key string, maxCount int,
+	fn func(sqlTx *datastore.SqlxTransaction, messages [][]byte) error) error {
+	for {
+		handled, err := q.popTx(key, maxCount, fn)
+		if err != nil {
+			return fmt.Errorf("PostgresQueue PopTx: %w", err)
+		}
+		if handled {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+// 在一个事务中取出消息并调用fn，没有到期的消息时不调用fn，handled为false
+func (q *PostgresQueue) popTx(key string, maxCount int,
+	fn func(sqlTx *datastore.SqlxTransaction, messages [][]byte) error) (handled bool, err error) {
+	sqlTx, err := datastore.NewTranscation()
+	if err != nil {
+		return false, fmt.Errorf("NewTranscation: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+				err = fmt.Errorf("%w\nRollback: %v", err, rollbackErr)
+			}
+		}
+	}()
+	messages, err := popMessages(sqlTx.NamedQuery, key, time.Now(), maxCount)
+	if err != nil {
+		return false, err
+	}
+	if len(messages) > 0 {
+		if err = fn(sqlTx, messages); err != nil {
+			return false, err
+		}
+	}
+	if err = sqlTx.Commit(); err != nil {
+		return false, fmt.Errorf("Commit: %w", err)
+	}
+	return len(messages) > 0, nil
+}
+
+func (q *PostgresQueue) Len(ctx context.Context, key string) (int64, error) {
+	return q.count(`select count(1) as count from queue_messages where queue = :queue and run_at <= now();`, key)
+}
+
+func (q *PostgresQueue) Schedule(ctx context.Context, key string, runAt time.Time, message []byte) error {
+	sqlText := `insert into queue_messages(queue, payload, run_at, create_time) values(:queue, :payload, :run_at, now());`
+	sqlParams := map[string]interface{}{"queue": key, "payload": message, "run_at": runAt}
+	if _, err := datastore.NamedExec(sqlText, sqlParams); err != nil {
+		return fmt.Errorf("PostgresQueue Schedule: %w", err)
+	}
+	return nil
+}
+
+func (q *PostgresQueue) PopDue(ctx context.Context, key string, now time.Time, limit int) ([][]byte, error) {
+	messages, err := q.pop(key, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("PostgresQueue PopDue: %w", err)
+	}
+	return messages, nil
+}
+
+func (q *PostgresQueue) ScheduledLen(ctx context.Context, key string) (int64, error) {
+	return q.count(`select count(1) as count from queue_messages where queue = :queue;`, key)
+}
+
+func (q *PostgresQueue) count(sqlText string, key string) (int64, error) {
+	var sqlResults []struct {
+		Count int64 `db:"count"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, map[string]interface{}{"queue": key})
+	if err != nil {
+		return 0, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return 0, fmt.Errorf("StructScan: %w", err)
+	}
+	if len(sqlResults) == 0 {
+		return 0, nil
+	}
+	return sqlResults[0].Count, nil
+}
+
+// Close 数据库连接由datastore统一管理
+func (q *PostgresQueue) Close() error {
+	return nil
+}
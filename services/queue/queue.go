@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/services/datastore"
+)
+
+// Queue 消息队列，同一个key内先进先出，延时消息单独使用有序的key保存
+type Queue interface {
+	// Push 一次写入多条消息
+	Push(ctx context.Context, key string, messages ...[]byte) error
+	// PopBatch 阻塞等待第一条消息，之后在maxWait时间内继续读取，最多返回maxCount条
+	// ctx取消时返回已读取的消息和ctx的错误
+	PopBatch(ctx context.Context, key string, maxCount int, maxWait time.Duration) ([][]byte, error)
+	Len(ctx context.Context, key string) (int64, error)
+	// Schedule 写入延时消息，到达runAt之后才能被PopDue取出
+	Schedule(ctx context.Context, key string, runAt time.Time, message []byte) error
+	// PopDue 取出已到期的延时消息，多个进程同时调用时每条消息只会被其中一个取出
+	PopDue(ctx context.Context, key string, now time.Time, limit int) ([][]byte, error)
+	ScheduledLen(ctx context.Context, key string) (int64, error)
+	Close() error
+}
+
+// TxQueue 取出消息与处理消息时的数据库写入在同一个事务中提交，fn返回错误时事务回滚，消息留在队列中
+// 只有postgres实现，redis取出消息后无法回滚
+type TxQueue interface {
+	// PopTx 阻塞等待到期的消息，最多取出maxCount条交给fn，ctx取消时返回ctx的错误
+	PopTx(ctx context.Context, key string, maxCount int,
+		fn func(sqlTx *datastore.SqlxTransaction, messages [][]byte) error) error
+}
+
+// 队列实现
+const (
+	DriverRedis    = "redis"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// Driver 读取QUEUE_DRIVER配置，未配置时有REDIS_URL则使用redis，否则使用postgres
+func Driver() string {
+	driver, ok := config.GetConfigurationString("QUEUE_DRIVER")
+	if ok && driver != "" {
+		return strings.ToLower(strings.TrimSpace(driver))
+	}
+	if redisUrl, ok := config.GetConfigurationString("REDIS_URL"); ok && redisUrl != "" {
+		return DriverRedis
+	}
+	return DriverPostgres
+}
+
+// CheckDriver 检查QUEUE_DRIVER配置，各进程启动时调用，配置错误时不启动
+// portal、worker和scheduler是独立的进程，进程内的memory队列无法在它们之间传递消息，不能通过配置使用
+func CheckDriver() error {
+	switch driver := Driver(); driver {
+	case DriverRedis, DriverPostgres:
+		return nil
+	case DriverMemory:
+		return fmt.Errorf("QUEUE_DRIVER不能为memory，消息只在进程内有效，其它进程收不到；测试中直接使用NewMemoryQueue")
+	default:
+		return fmt.Errorf("不支持的QUEUE_DRIVER: %s", driver)
+	}
+}
+
+// Open 按配置打开消息队列，postgres实现依赖已经初始化的datastore
+func Open(ctx context.Context) (Queue, error) {
+	if err := CheckDriver(); err != nil {
+		return nil, err
+	}
+	switch driver := Driver(); driver {
+	case DriverRedis:
+		redisUrl, ok := config.GetConfigurationString("REDIS_URL")
+		if !ok || redisUrl == "" {
+			return nil, fmt.Errorf("QUEUE_DRIVER为redis时需要配置REDIS_URL")
+		}
+		return NewRedisQueue(ctx, redisUrl)
+	case DriverPostgres:
+		return NewPostgresQueue(), nil
+	default:
+		return nil, fmt.Errorf("不支持的QUEUE_DRIVER: %s", driver)
+	}
+}
+
+var (
+	_ Queue = (*RedisQueue)(nil)
+	_ Queue = (*PostgresQueue)(nil)
+	_ Queue = (*MemoryQueue)(nil)
+
+	_ TxQueue = (*PostgresQueue)(nil)
+)
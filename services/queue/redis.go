@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 阻塞读取时单次等待的上限，到期后检查ctx是否已取消再继续等待
+const redisBlockTimeout = 5 * time.Second
+
+// RedisQueue 基于Redis列表的消息队列，LPUSH写入，BRPOP读取，先进先出
+type RedisQueue struct {
+	client *redis.Client
+}
+
+func NewRedisQueue(ctx context.Context, redisUrl string) (*RedisQueue, error) {
+	options, err := redis.ParseURL(redisUrl)
+	if err != nil {
+		return nil, fmt.Errorf("NewRedisQueue ParseURL: %w", err)
+	}
+	client := redis.NewClient(options)
+	if err = client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("NewRedisQueue Ping: %w", err)
+	}
+	return &RedisQueue{client: client}, nil
+}
+
+func (q *RedisQueue) Close() error {
+	return q.client.Close()
+}
+
+// Push 一次写入多条消息
+func (q *RedisQueue) Push(ctx context.Context, key string, messages ...[]byte) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	values := make([]interface{}, 0, len(messages))
+	for _, item := range messages {
+		values = append(values, item)
+	}
+	if err := q.client.LPush(ctx, key, values...).Err(); err != nil {
+		return fmt.Errorf("RedisQueue Push: %w", err)
+	}
+	return nil
+}
+
+// PopBatch 阻塞等待第一条消息，之后在maxWait时间内继续读取，最多返回maxCount条
+// ctx取消时返回已读取的消息和ctx的错误
+func (q *RedisQueue) PopBatch(ctx context.Context, key string, maxCount int,
+	maxWait time.Duration) ([][]byte, error) {
+	messages := make([][]byte, 0, maxCount)
+
+	for len(messages) == 0 {
+		if err := ctx.Err(); err != nil {
+			return messages, err
+		}
+		message, err := q.blockingPop(ctx, key, redisBlockTimeout)
+		if err != nil {
+			return messages, err
+		}
+		if message != nil {
+			messages = append(messages, message)
+		}
+	}
+
+	deadline := time.Now().Add(maxWait)
+	for len(messages) < maxCount {
+		// 队列中已有的消息直接批量取出，不再等待
+		values, err := q.client.RPopCount(ctx, key, maxCount-len(messages)).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return messages, fmt.Errorf("RedisQueue RPopCount: %w", err)
+		}
+		for _, item := range values {
+			messages = append(messages, []byte(item))
+		}
+		remaining := time.Until(deadline)
+		if len(messages) >= maxCount || remaining <= 0 {
+			break
+		}
+		if len(values) > 0 {
+			continue
+		}
+		message, err := q.blockingPop(ctx, key, remaining)
+		if err != nil {
+			return messages, err
+		}
+		if message == nil {
+			break
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// 超时返回nil消息
+func (q *RedisQueue) blockingPop(ctx context.Context, key string, timeout time.Duration) ([]byte, error) {
+	// BRPOP的超时精度为毫秒，过小的值会被当作0而永久阻塞
+	if timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+	values, err := q.client.BRPop(ctx, timeout, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("RedisQueue BRPop: %w", err)
+	}
+	// 返回值为[key, value]
+	if len(values) < 2 {
+		return nil, nil
+	}
+	return []byte(values[1]), nil
+}
+
+func (q *RedisQueue) Len(ctx context.Context, key string) (int64, error) {
+	length, err := q.client.LLen(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("RedisQueue Len: %w", err)
+	}
+	return length, nil
+}
+
+// Schedule 写入延时消息，到达runAt之后才能被PopDue取出
+func (q *RedisQueue) Schedule(ctx context.Context, key string, runAt time.Time, message []byte) error {
+	member := redis.Z{Score: float64(runAt.UnixMilli()), Member: message}
+	if err := q.client.ZAdd(ctx, key, member).Err(); err != nil {
+		return fmt.Errorf("RedisQueue Schedule: %w", err)
+	}
+	return nil
+}
+
+// PopDue 取出已到期的延时消息，多个进程同时调用时每条消息只会被其中一个取出
+func (q *RedisQueue) PopDue(ctx context.Context, key string, now time.Time, limit int) ([][]byte, error) {
+	values, err := q.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("RedisQueue PopDue: %w", err)
+	}
+	messages := make([][]byte, 0, len(values))
+	for _, item := range values {
+		removed, err := q.client.ZRem(ctx, key, item).Result()
+		if err != nil {
+			return messages, fmt.Errorf("RedisQueue PopDue ZRem: %w", err)
+		}
+		if removed > 0 {
+			messages = append(messages, []byte(item))
+		}
+	}
+	return messages, nil
+}
+
+func (q *RedisQueue) ScheduledLen(ctx context.Context, key string) (int64, error) {
+	length, err := q.client.ZCard(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("RedisQueue ScheduledLen: %w", err)
+	}
+	return length, nil
+}
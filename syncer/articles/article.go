@@ -1,30 +1,24 @@
 package articles
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"portal/services"
-	"portal/services/PTFilesystem"
 	"portal/services/PTHash"
-	"portal/services/base58"
 
-	"gopkg.in/yaml.v3"
-
-	"github.com/pnnh/neutron/config"
 	"github.com/pnnh/neutron/helpers/jsonmap"
-	"github.com/pnnh/neutron/services/filesystem"
 
 	"github.com/pnnh/neutron/helpers"
 	"github.com/pnnh/neutron/services/checksum"
 	"github.com/sirupsen/logrus"
 )
 
-// 一个固定的用户ID，通过Syncer服务同步的文章属于这个用户
+// 同步源没有配置owner和parent时，同步的文件属于这个用户，放在这个目录下
 const SyncerArticleOwner = "01990e6a-2689-731b-a5a2-b46117e22040"
 const SyncParentUid = "76de121c-0fab-11f1-a643-6c02e0549f86"
 
@@ -35,60 +29,238 @@ type dirStat struct {
 }
 
 type ArticleWorker struct {
-	repoWorker *RepoWorker
-	rootPath   string
-	repoId     string
-	syncno     string
-	dirStatMap map[string]*dirStat
+	ctx               context.Context
+	repoWorker        *RepoWorker
+	source            *SyncSource
+	rootPath          string
+	parentPath        string // 目标父目录的path，postgresql ltree格式
+	repoId            string
+	repoIdGenerated   bool   // repoId是本次生成的，同步前写入repo.yml
+	uidPath           string // 计算文件uid使用的路径，UidPathAbsolute或UidPathRelative
+	uidPathConfigured bool   // repo.yml中配置了UIDPATH，写入REPOID时不再写入
+	owner             string // 文件的所有者，同步源没有配置时使用repo.yml中的OWNER
+	channel           string
+	lang              string
+	syncno            string
+	dirStatMap        map[string]*dirStat
+	synced            map[string]*syncedFile // 数据库中已有的文件
+	syncedByName      map[string]*syncedFile // 按 父目录uid/文件名 索引未删除的synced，按路径查找时生成
+	visited           map[string]struct{}    // 本次同步遍历到的文件
+	unchanged         []string               // 未变化的文件，同步结束后统一更新批次号
+	stats             *SyncStats
+	git               *gitState // 同步目录不是git仓库时为nil
+	ignore            *ignoreRules
+	writer            FileWriter
+	plan              *SyncPlan         // 不为nil时只生成同步计划
+	linkUrls          map[string]string // 文章中链接的文件路径和存储地址
+	brokenLinks       []*brokenLink
 }
 
-func NewArticleWorker(repoWorker *RepoWorker, rootPath string, syncno string) (*ArticleWorker, error) {
+func NewArticleWorker(repoWorker *RepoWorker, source *SyncSource, syncno string) (*ArticleWorker, error) {
+	rootPath := source.RootPath
+	parentPath, err := PGSelectFilePath(source.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("查询目标父目录出错: %w", err)
+	}
+	// 父目录还没有记录时按顶层目录处理
+	if parentPath == "" {
+		parentPath = source.Parent
+	}
 	worker := &ArticleWorker{
 		repoWorker: repoWorker,
+		source:     source,
 		rootPath:   rootPath,
+		parentPath: parentPath,
 		syncno:     syncno,
 		dirStatMap: make(map[string]*dirStat),
+		visited:    make(map[string]struct{}),
+		stats:      &SyncStats{},
+		linkUrls:   make(map[string]string),
+	}
+	worker.dirStatMap[rootPath] = &dirStat{uid: source.Parent, synced: true, path: parentPath}
+	repoConfig, err := readRepoConfig(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	worker.git = loadGitState(source)
+	worker.repoId, worker.repoIdGenerated, err = resolveRepoId(repoConfig, worker.git)
+	if err != nil {
+		return nil, err
+	}
+	worker.uidPath, worker.uidPathConfigured = uidPathMode(repoConfig), repoConfig.UidPath != ""
+	// 同步源的配置优先于repo.yml
+	worker.owner, worker.channel, worker.lang = source.Owner, source.Channel, repoConfig.Lang
+	if source.ownerDefaulted && repoConfig.Owner != "" {
+		worker.owner = repoConfig.Owner
+	}
+	if worker.channel == "" {
+		worker.channel = repoConfig.Channel
+	}
+	worker.writer, err = newFileWriter()
+	if err != nil {
+		return nil, err
 	}
-	worker.dirStatMap[rootPath] = &dirStat{uid: SyncParentUid, synced: true, path: SyncParentUid}
-	repoFilePath := filepath.Join(rootPath, ".polaris", "repo.yml")
+	if source.Branch != "" && (worker.git == nil || worker.git.info.Branch != source.Branch) {
+		return nil, fmt.Errorf("同步源 %s 不是%s分支", source.Name, source.Branch)
+	}
+	gitRoot := ""
+	if worker.git != nil {
+		gitRoot = worker.git.rootDir(rootPath)
+	}
+	worker.ignore, err = loadIgnoreRules(source, gitRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return worker, nil
+}
 
-	repoFilePath, err := filesystem.ResolvePath(repoFilePath)
+// StartWork 遍历目录同步文章，等待文件复制完成后清理已删除的文件，ctx取消后停止遍历，已提交的复制任务仍会执行完
+func (w *ArticleWorker) StartWork(ctx context.Context) error {
+	w.ctx = ctx
+	if err := w.saveRepoId(); err != nil {
+		return err
+	}
+	w.synced = loadSyncedFiles(w.parentPath)
+	err := filepath.Walk(w.rootPath, w.visitFile)
 	if err != nil {
-		return nil, fmt.Errorf("ParseConfigFile ResolvePath: %w", err)
+		return fmt.Errorf("error walking the path %s: %w", w.rootPath, err)
 	}
-	if _, err := os.Stat(repoFilePath); !os.IsNotExist(err) {
-		repoFileString, err := PTFilesystem.PTReadFileAsString(repoFilePath)
-		if err != nil {
-			return nil, fmt.Errorf("读取repo.yml文件失败: %w", err)
+	if ctx.Err() != nil {
+		logrus.Warnln("同步被中断", w.rootPath)
+		w.finish()
+		return nil
+	}
+	for uid, item := range w.synced {
+		if _, ok := w.visited[uid]; !ok && !item.Removed {
+			w.stats.Removed += 1
+		}
+	}
+	w.finish()
+	// 有文件同步失败时不清理，避免把失败的文件当作已删除
+	if w.stats.Failed > 0 {
+		logrus.Warnln("存在同步失败的文件，跳过清理已删除的文件", w.stats.Failed)
+		return nil
+	}
+	if !w.waitCopies() {
+		return nil
+	}
+	if err := w.prune(); err != nil {
+		return fmt.Errorf("清理已删除的文件出错: %w", err)
+	}
+	w.recordRepoSync()
+	return nil
+}
+
+// 等待文件复制完成后才清理和记录同步的提交，有文件复制失败时存储中缺少文件，返回false
+func (w *ArticleWorker) waitCopies() bool {
+	if failed := w.repoWorker.CloseAndWait().Failed.Load(); failed > 0 {
+		logrus.Warnln("存在复制失败的文件，跳过清理已删除的文件和记录同步的提交", failed)
+		return false
+	}
+	return true
+}
+
+// Stats 本次同步的文件数量统计
+func (w *ArticleWorker) Stats() *SyncStats {
+	return w.stats
+}
+
+func (w *ArticleWorker) finish() {
+	w.writer.Flush(w.ctx)
+	if err := PGTouchSyncedFiles(w.syncno, w.unchanged); err != nil {
+		logrus.Errorln("更新未变化文件的批次号出错", err)
+	}
+	w.unchanged = nil
+	w.reportBrokenLinks()
+	logrus.Infoln("同步完成", w.rootPath, w.stats)
+}
+
+// SyncPaths 只同步指定的文件或目录，目录会同步其下的全部内容，上级目录按已经同步处理
+// 已经删除的路径留给全量同步处理
+func (w *ArticleWorker) SyncPaths(ctx context.Context, paths []string) error {
+	w.ctx = ctx
+	if err := w.saveRepoId(); err != nil {
+		return err
+	}
+	w.synced = loadSyncedFiles(w.parentPath)
+	defer w.finish()
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			logrus.Warnln("同步被中断", w.rootPath)
+			return nil
+		}
+		if !strings.HasPrefix(path, w.rootPath+string(os.PathSeparator)) || w.inIgnoredDir(path) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			logrus.Infoln("文件已不存在，跳过", path)
+			continue
 		}
-		configMap := make(map[string]any)
-		err = yaml.Unmarshal([]byte(repoFileString), &configMap)
 		if err != nil {
-			return nil, fmt.Errorf("解析配置内容出错: %w", err)
+			return fmt.Errorf("SyncPaths Lstat: %w", err)
 		}
-		if repoIdValue, ok := configMap["REPOID"]; ok {
-			if repoIdStr, ok := repoIdValue.(string); ok && helpers.IsUuid(repoIdStr) {
-				worker.repoId = repoIdStr
+		// .note目录的文章内容在index.md中，index.md变化时同时更新目录对应的文章
+		if noteDir := filepath.Dir(path); filepath.Base(path) == "index.md" && isNotePath(noteDir, true) {
+			if dirInfo, err := os.Lstat(noteDir); err == nil {
+				w.ensureDirStat(filepath.Dir(noteDir))
+				if err := w.visitFile(noteDir, dirInfo, nil); err != nil && !errors.Is(err, filepath.SkipDir) {
+					return fmt.Errorf("SyncPaths %s: %w", noteDir, err)
+				}
 			}
 		}
+		w.ensureDirStat(filepath.Dir(path))
+		if info.IsDir() {
+			err = filepath.Walk(path, w.visitFile)
+		} else {
+			err = w.visitFile(path, info, nil)
+		}
+		if err != nil && !errors.Is(err, filepath.SkipDir) && !errors.Is(err, filepath.SkipAll) {
+			return fmt.Errorf("SyncPaths %s: %w", path, err)
+		}
 	}
-	if worker.repoId == "" {
-		worker.repoId = helpers.MustUuid()
-	}
+	return nil
+}
 
-	return worker, nil
+// 路径位于隐藏目录或忽略的目录中
+func (w *ArticleWorker) inIgnoredDir(path string) bool {
+	for dir := filepath.Dir(path); dir != w.rootPath && len(dir) > len(w.rootPath); dir = filepath.Dir(dir) {
+		if strings.HasPrefix(filepath.Base(dir), ".") || w.ignore.isIgnored(dir, true) {
+			return true
+		}
+	}
+	return false
 }
 
-func (w *ArticleWorker) StartWork() {
-	err := filepath.Walk(w.rootPath, w.visitFile)
+// 按目录路径计算出目录的统计信息，与全量遍历时得到的结果一致
+func (w *ArticleWorker) ensureDirStat(dir string) *dirStat {
+	if stat, ok := w.dirStatMap[dir]; ok {
+		return stat
+	}
+	parentStat := w.ensureDirStat(filepath.Dir(dir))
+	uid, err := w.resolveUid(dir, true)
 	if err != nil {
-		logrus.Fatalln("error walking the path", w.rootPath, err)
+		logrus.Errorf("CalcFileUid Uid err: %+v", err)
+		return &dirStat{}
 	}
+	stat := &dirStat{uid: uid, synced: parentStat.synced, path: parentStat.path + "." + uid}
+	w.dirStatMap[dir] = stat
+	return stat
 }
 
-// 通过rootPath和文件相对路径计算出一个唯一的文件UID，保证同一目录结构下相同文件路径的UID一致
+// 通过repoId和文件路径计算出文件UID，使用相对于同步目录的路径时，同一仓库克隆到不同位置或换一台机器同步UID保持一致
+// 相对路径统一以/分隔，使用绝对路径时与之前版本同步的UID一致
 func (w *ArticleWorker) calcFileUid(path string) (string, error) {
-	rawStr := fmt.Sprintf("%s-%s", w.repoId, path)
+	uidPath := path
+	if w.uidPath == UidPathRelative {
+		relativePath, err := filepath.Rel(w.rootPath, path)
+		if err != nil {
+			return "", fmt.Errorf("计算文件相对路径失败: %w", err)
+		}
+		uidPath = filepath.ToSlash(relativePath)
+	}
+	rawStr := fmt.Sprintf("%s-%s", w.repoId, uidPath)
 	md5Val, err := PTHash.PTCalculateMD5String(rawStr)
 	if err != nil {
 		return "", fmt.Errorf("计算文件UID失败: %w", err)
@@ -101,6 +273,9 @@ func (w *ArticleWorker) calcFileUid(path string) (string, error) {
 }
 
 func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error) error {
+	if w.ctx != nil && w.ctx.Err() != nil {
+		return filepath.SkipAll
+	}
 	if visitErr != nil {
 		return fmt.Errorf("error walking the path %s, %w", path, visitErr)
 	}
@@ -112,7 +287,7 @@ func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error)
 	if info.IsDir() && strings.HasPrefix(fileName, ".") {
 		return filepath.SkipDir
 	}
-	if filesystem.IsIgnoredPath(path) {
+	if w.ignore.isIgnored(path, info.IsDir()) {
 		if info.IsDir() {
 			return filepath.SkipDir
 		}
@@ -121,10 +296,17 @@ func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error)
 	}
 	sumValue := ""
 	mimeType := ""
-	newUid, err := w.calcFileUid(path)
+	note, err := readNote(path, info.IsDir())
 	if err != nil {
-		logrus.Errorf("CalcFileUid Uid err: %+v", err)
-		return nil
+		logrus.Warnln("读取文章元数据出错，按普通文件同步", path, err)
+	}
+	newUid := note.stableUid()
+	if newUid == "" {
+		newUid, err = w.calcFileUid(path)
+		if err != nil {
+			logrus.Errorf("CalcFileUid Uid err: %+v", err)
+			return nil
+		}
 	}
 	parentUid := ""
 	parentDir := filepath.Dir(path)
@@ -135,6 +317,10 @@ func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error)
 	if info.IsDir() {
 		mimeType = "directory"
 		w.dirStatMap[path] = &dirStat{uid: newUid, synced: false, path: parentDirStat.path + "." + newUid}
+		// .note目录按index.md的内容判断是否变化
+		if note != nil {
+			sumValue = note.checksum
+		}
 	} else {
 		sum, err := checksum.CalcSha256(path)
 		if err != nil {
@@ -150,6 +336,9 @@ func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error)
 	parentUid = parentDirStat.uid
 
 	noteTitle := strings.Trim(fileName, " \n\r\t ")
+	if note != nil {
+		noteTitle = strings.Trim(note.matter.Title, " \n\r\t ")
+	}
 	if noteTitle == "" {
 		noteTitle = strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
 	}
@@ -164,14 +353,14 @@ func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error)
 	dataRow.SetString("keywords", "")
 	dataRow.SetInt("status", 1)
 	dataRow.SetNullString("cover", "")
-	dataRow.SetString("owner", SyncerArticleOwner)
-	dataRow.SetNullString("channel", "")
+	dataRow.SetString("owner", w.owner)
+	dataRow.SetNullString("channel", w.channel)
 	dataRow.SetInt("discover", 0)
 	dataRow.SetNullString("partition", "")
 	dataRow.SetTime("create_time", nowTime)
 	dataRow.SetTime("update_time", nowTime)
 	dataRow.SetNullString("version", "0")
-	dataRow.SetString("lang", "")
+	dataRow.SetString("lang", w.lang)
 	dataRow.SetNullString("parent", parentUid)
 	dataRow.SetNullString("name", fileName)
 	dataRow.SetNullString("checksum", sumValue)
@@ -179,28 +368,32 @@ func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error)
 	dataRow.SetNullString("mimetype", mimeType)
 	dataRow.SetString("url", "")
 	dataRow.SetString("path", w.dirStatMap[parentDir].path+"."+newUid)
+	dataRow.SetInt("article", 0)
+	if note != nil {
+		w.setNoteFields(dataRow, note)
+	} else if strings.HasPrefix(mimeType, "text/") && !isNotePath(parentDir, true) {
+		// .note目录中的文件都是文章的资源
+		dataRow.SetInt("article", 1)
+	}
+	if w.git != nil {
+		dataRow.SetNullString("repo_url", w.git.info.RemoteUrl)
+		dataRow.SetNullString("branch", w.git.info.Branch)
+		dataRow.SetNullString("commit", w.git.info.CommitId)
+		dataRow.SetString("commit_time", w.git.info.CommitTime.Format(time.RFC3339))
+		dataRow.SetNullString("relative_path", w.relativePath(path))
+		dataRow.SetNullString("repo_id", w.repoId)
+		dataRow.SetNullString("repo_first_commit", w.git.info.FirstCommitId)
+	}
 
 	targetPath := ""
 	if !info.IsDir() {
-		logrus.Infoln("同步文件: ", path, "，checksum: ", sumValue)
+		logrus.Debugln("同步文件: ", path, "，checksum: ", sumValue)
 
-		targetParentDir, err := base58.UuidToBase58(parentUid)
+		targetPath, err = storageTargetPath(parentUid, newUid, fileName)
 		if err != nil {
-			logrus.Errorf("转换父目录UID失败: %v", err)
+			logrus.Errorln(err)
 			return nil
 		}
-		targetSelfName, err := base58.UuidToBase58(newUid)
-		if err != nil {
-			logrus.Errorf("转换文件UID失败: %v", err)
-			return nil
-		}
-		if !strings.HasPrefix(fileName, ".") {
-			extName := filepath.Ext(fileName)
-			if extName != "" {
-				targetSelfName += extName
-			}
-		}
-		targetPath = fmt.Sprintf("%s/%s", targetParentDir, targetSelfName)
 		targetUrl := fmt.Sprintf("storage://%s", targetPath)
 		dataRow.SetString("url", targetUrl)
 	}
@@ -210,45 +403,56 @@ func (w *ArticleWorker) visitFile(path string, info os.FileInfo, visitErr error)
 		return nil
 	}
 
-	portalUrl, ok := config.GetConfigurationString("INTERNAL_PORTAL_URL")
-	if !ok || portalUrl == "" {
-		return fmt.Errorf("INTERNAL_PORTAL_URL 未配置2")
-	}
-	// 插入文章数据到Portal服务，仅开发阶段执行
-	postUrl := fmt.Sprintf("%s/cloud/files/%s?debug=true", portalUrl, newUid)
-	dataString, err := services.PTMarshalJsonMapToString(dataRow)
-	if err != nil {
-		return fmt.Errorf("序列化数据失败: %w", err)
-	}
-	reader := strings.NewReader(dataString)
-	request, err := http.NewRequest("POST", postUrl, reader)
-	if err != nil {
-		return fmt.Errorf("创建HTTP请求失败: %w", err)
-	}
-	request.Header.Set("Content-Type", "application/json")
-	//err := PGInsertFile(dataRow)
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		logrus.Errorf("插入文件数据失败: %s %v", fileName, err)
+	w.visited[newUid] = struct{}{}
+	existing := w.synced[newUid]
+	if existing != nil && !existing.Removed && existing.Checksum == sumValue && existing.Parent == parentUid &&
+		existing.Name == fileName {
+		w.stats.Unchanged += 1
+		if info.IsDir() {
+			w.dirStatMap[path].synced = true
+		}
+		if w.plan != nil {
+			return nil
+		}
+		w.unchanged = append(w.unchanged, newUid)
+		if !w.repoFileSaved(newUid) {
+			w.saveRepoFile(newUid, path, targetPath, sumValue, mimeType, info.IsDir())
+		}
+		// 上次复制失败的文件已经清空了校验和，不会走到这里，未变化的文件不再复制
 		return nil
 	}
-	defer func() {
-		if err := response.Body.Close(); err != nil {
-			logrus.Errorf("关闭HTTP响应体失败: %v", err)
+
+	isDir := info.IsDir()
+	// 只生成同步计划时不写入，目录按已同步处理以继续遍历其下的文件
+	if w.plan != nil {
+		w.addPlanItem(existing, path, &PlanItem{Uid: newUid, Name: fileName, Parent: parentUid, Directory: isDir,
+			Checksum: sumValue, Url: dataRow.GetString("url")})
+		if isDir {
+			w.dirStatMap[path].synced = true
 		}
-	}()
-	if response.StatusCode != http.StatusOK {
-		logrus.Errorf("插入文件数据失败，HTTP状态码: %d", response.StatusCode)
 		return nil
 	}
-
-	if !info.IsDir() {
-		w.repoWorker.AddJob(path, targetPath)
-	} else {
-		currentDirStat := w.dirStatMap[path]
-		if currentDirStat != nil {
+	w.writer.Write(w.ctx, dataRow, func(err error) {
+		if err != nil {
+			logrus.Errorf("写入文件数据失败: %s %v", path, err)
+			w.stats.Failed += 1
+			return
+		}
+		if existing == nil {
+			w.stats.Added += 1
+		} else {
+			w.stats.Changed += 1
+		}
+		w.saveRepoFile(newUid, path, targetPath, sumValue, mimeType, isDir)
+		if !isDir {
+			w.repoWorker.AddJob(newUid, path, targetPath, sumValue)
+		} else if currentDirStat := w.dirStatMap[path]; currentDirStat != nil {
 			currentDirStat.synced = true
 		}
+	})
+	// 目录写入成功后才同步其下的文件
+	if isDir {
+		w.writer.Flush(w.ctx)
 	}
 
 	return nil
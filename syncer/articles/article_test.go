@@ -0,0 +1,102 @@
+package articles
+
+import (
+	"path/filepath"
+	"testing"
+
+	"portal/services/PTHash"
+)
+
+func TestCalcFileUidRelative(t *testing.T) {
+	first := &ArticleWorker{rootPath: filepath.FromSlash("/data/a/blog"), repoId: "repo", uidPath: UidPathRelative}
+	second := &ArticleWorker{rootPath: filepath.FromSlash("/srv/cache/blog-1"), repoId: "repo", uidPath: UidPathRelative}
+	other := &ArticleWorker{rootPath: filepath.FromSlash("/data/a/blog"), repoId: "other", uidPath: UidPathRelative}
+	rel := filepath.FromSlash("posts/a.md")
+
+	uid1, err := first.calcFileUid(filepath.Join(first.rootPath, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid2, err := second.calcFileUid(filepath.Join(second.rootPath, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid1 != uid2 {
+		t.Errorf("同一仓库不同位置的uid不同: %s %s", uid1, uid2)
+	}
+	uid3, err := other.calcFileUid(filepath.Join(other.rootPath, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid1 == uid3 {
+		t.Errorf("不同仓库相同路径的uid相同: %s", uid1)
+	}
+	uid4, err := first.calcFileUid(filepath.Join(first.rootPath, filepath.FromSlash("posts/b.md")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid1 == uid4 {
+		t.Errorf("同一仓库不同路径的uid相同: %s", uid1)
+	}
+}
+
+func TestCalcFileUidAbsolute(t *testing.T) {
+	worker := &ArticleWorker{rootPath: filepath.FromSlash("/data/a/blog"), repoId: "repo", uidPath: UidPathAbsolute}
+	path := filepath.Join(worker.rootPath, filepath.FromSlash("posts/a.md"))
+	got, err := worker.calcFileUid(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与之前版本按绝对路径计算的uid一致
+	md5Val, err := PTHash.PTCalculateMD5String("repo-" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := PTHash.PTMd5ToUuid(md5Val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("calcFileUid(%q) = %s, want %s", path, got, want)
+	}
+}
+
+func TestUidPathMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RepoConfig
+		want   string
+	}{
+		{"configured repo id", RepoConfig{RepoId: "0b3f0f5e-3c1a-4b8e-9a51-7c2d6e8f9a10"}, UidPathAbsolute},
+		{"no repo id", RepoConfig{}, UidPathRelative},
+		{"configured relative", RepoConfig{RepoId: "0b3f0f5e-3c1a-4b8e-9a51-7c2d6e8f9a10", UidPath: UidPathRelative}, UidPathRelative},
+		{"configured absolute", RepoConfig{UidPath: UidPathAbsolute}, UidPathAbsolute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uidPathMode(&tt.config); got != tt.want {
+				t.Errorf("uidPathMode = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteRepoIdKeepsUidPath(t *testing.T) {
+	rootPath := t.TempDir()
+	writeTestFile(t, repoConfigPath(rootPath), "LANG: zh")
+	repoId := "0b3f0f5e-3c1a-4b8e-9a51-7c2d6e8f9a10"
+	if err := writeRepoId(rootPath, repoId, UidPathRelative); err != nil {
+		t.Fatal(err)
+	}
+	repoConfig, err := readRepoConfig(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repoConfig.RepoId != repoId || repoConfig.Lang != "zh" {
+		t.Errorf("readRepoConfig = %+v", repoConfig)
+	}
+	// 写入REPOID后再次同步仍按相对路径计算uid
+	if got := uidPathMode(repoConfig); got != UidPathRelative {
+		t.Errorf("uidPathMode = %s, want %s", got, UidPathRelative)
+	}
+}
@@ -2,21 +2,28 @@ package articles
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"portal/services/PTFilesystem"
+	"portal/services/confighelper"
 
 	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/services/checksum"
 	"github.com/pnnh/neutron/services/filesystem"
 
 	"github.com/sirupsen/logrus"
 )
 
 type CopyJob struct {
+	uid        string // 文件的uid，复制失败时清空数据库中的校验和
 	sourcePath string
 	targetPath string
+	checksum   string // 源文件的sha256，目标文件相同时跳过复制
 }
 
 type MTFilePorter struct {
@@ -27,26 +34,85 @@ func MTNewFilePorter(targetPath string) (*MTFilePorter, error) {
 
 	resolvedPath, err := filesystem.ResolvePath(targetPath)
 	if err != nil {
-		logrus.Fatalln("NewFilePorter解析路径失败", err)
-		return nil, fmt.Errorf("NewFilePorter解析路径失败")
+		return nil, fmt.Errorf("NewFilePorter解析路径失败: %w", err)
 	}
 	return &MTFilePorter{targetRootPath: resolvedPath}, nil
 }
 
-func (p *MTFilePorter) CopyFile(srcPath, targetPath string) (string, error) {
-	targetDir := filepath.Dir(targetPath)
-	fullTargetDir := filepath.Join(p.targetRootPath, string(os.PathSeparator), targetDir)
+// CopyFile 先写入同目录下的临时文件再改名，复制中断时不会留下不完整的目标文件
+// 目标文件的校验和与sum相同时跳过，返回写入的字节数，跳过时为-1
+func (p *MTFilePorter) CopyFile(srcPath, targetPath, sum string) (int64, error) {
+	fullTargetPath := filepath.Join(p.targetRootPath, string(os.PathSeparator), targetPath)
+	if sum != "" && sameChecksum(srcPath, fullTargetPath, sum) {
+		return -1, nil
+	}
+	fullTargetDir := filepath.Dir(fullTargetPath)
 	err := PTFilesystem.MTCreateDir(fullTargetDir)
 	if err != nil {
-		return "", fmt.Errorf("MkdirAll: %w", err)
+		return 0, fmt.Errorf("MkdirAll: %w", err)
 	}
-	fullTargetPath := filepath.Join(p.targetRootPath, string(os.PathSeparator), targetPath)
-	err = filesystem.CopyFile(srcPath, fullTargetPath)
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("Open: %w", err)
+	}
+	defer func() {
+		if err := srcFile.Close(); err != nil {
+			logrus.Warnln("关闭源文件出错", srcPath, err)
+		}
+	}()
+	tempFile, err := os.CreateTemp(fullTargetDir, "."+filepath.Base(fullTargetPath)+".*.tmp")
 	if err != nil {
-		return "", fmt.Errorf("CopyFile: %w", err)
+		return 0, fmt.Errorf("CreateTemp: %w", err)
+	}
+	tempPath := tempFile.Name()
+	written, err := io.Copy(tempFile, srcFile)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, fullTargetPath)
+	}
+	if err != nil {
+		if removeErr := os.Remove(tempPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			logrus.Warnln("删除临时文件出错", tempPath, removeErr)
+		}
+		return 0, fmt.Errorf("CopyFile: %w", err)
+	}
+
+	return written, nil
+}
+
+// 大小不同时不再计算校验和
+func sameChecksum(srcPath, fullTargetPath, sum string) bool {
+	targetInfo, err := os.Stat(fullTargetPath)
+	if err != nil || !targetInfo.Mode().IsRegular() {
+		return false
 	}
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil || srcInfo.Size() != targetInfo.Size() {
+		return false
+	}
+	targetSum, err := checksum.CalcSha256(fullTargetPath)
+	return err == nil && targetSum == sum
+}
 
-	return fullTargetPath, nil
+// CopyStats 文件复制的数量统计，各复制协程并发更新
+type CopyStats struct {
+	Copied  atomic.Int64
+	Skipped atomic.Int64 // 目标文件已经相同
+	Bytes   atomic.Int64
+	Failed  atomic.Int64
+}
+
+func (s *CopyStats) String() string {
+	return fmt.Sprintf("copied=%d skipped=%d bytes=%d failed=%d",
+		s.Copied.Load(), s.Skipped.Load(), s.Bytes.Load(), s.Failed.Load())
 }
 
 type RepoWorker struct {
@@ -54,49 +120,122 @@ type RepoWorker struct {
 	filePorter *MTFilePorter
 	wg         *sync.WaitGroup
 	syncno     string
+	workers    int
+	retries    int
+	stats      *CopyStats
+	closeOnce  sync.Once
+	done       chan struct{} // StartWork退出时关闭
 }
 
 func NewRepoWorker(wg *sync.WaitGroup, syncno string) (*RepoWorker, error) {
 	storageUrl, ok := config.GetConfigurationString("STORAGE_URL")
 	if !ok || storageUrl == "" {
-		logrus.Fatalln("STORAGE_URL 未配置")
+		return nil, fmt.Errorf("STORAGE_URL 未配置")
 	}
 	filePorter, err := MTNewFilePorter(storageUrl)
 	if err != nil {
-		logrus.Errorln("初始化FilePorter失败", err)
-		return nil, fmt.Errorf("初始化FilePorter失败")
+		return nil, fmt.Errorf("初始化FilePorter失败: %w", err)
 	}
+	workers := max(confighelper.GetInt("SYNC_COPY_WORKERS", 4), 1)
 
 	return &RepoWorker{
-		repoChan:   make(chan *CopyJob, 3),
+		repoChan:   make(chan *CopyJob, workers*2),
 		filePorter: filePorter,
 		wg:         wg,
 		syncno:     syncno,
+		workers:    workers,
+		retries:    max(confighelper.GetInt("SYNC_COPY_RETRIES", 2), 0),
+		stats:      &CopyStats{},
+		done:       make(chan struct{}),
 	}, nil
 }
 
-func (w *RepoWorker) AddJob(sourcePath, targetPath string) {
+func (w *RepoWorker) AddJob(uid, sourcePath, targetPath, sum string) {
 	copyStruct := &CopyJob{
+		uid:        uid,
 		sourcePath: sourcePath,
 		targetPath: targetPath,
+		checksum:   sum,
 	}
 	w.repoChan <- copyStruct
 }
 
+// Close 不再接收新的复制任务，StartWork处理完已提交的任务后退出，可以重复调用
+func (w *RepoWorker) Close() {
+	w.closeOnce.Do(func() {
+		close(w.repoChan)
+	})
+}
+
+// CloseAndWait 关闭后等待已提交的复制任务全部完成，之后Stats是最终结果
+func (w *RepoWorker) CloseAndWait() *CopyStats {
+	w.Close()
+	<-w.done
+	return w.stats
+}
+
+// Stats 文件复制的统计，StartWork退出后是最终结果
+func (w *RepoWorker) Stats() *CopyStats {
+	return w.stats
+}
+
+// StartWork 启动SYNC_COPY_WORKERS个协程复制文件，每10秒输出一次进度，全部退出后输出汇总
 func (w *RepoWorker) StartWork() {
 	defer func() {
-		logrus.Infoln("RepoWorker 退出")
+		logrus.Infoln("RepoWorker 退出", w.stats)
+		close(w.done)
 		w.wg.Done()
 	}()
+	copyWg := &sync.WaitGroup{}
+	for range w.workers {
+		copyWg.Add(1)
+		go func() {
+			defer copyWg.Done()
+			for copyStruct := range w.repoChan {
+				w.copyWithRetry(copyStruct)
+			}
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		copyWg.Wait()
+		close(finished)
+	}()
+	progressTicker := time.NewTicker(10 * time.Second)
+	defer progressTicker.Stop()
 	for {
 		select {
-		case copyStruct := <-w.repoChan:
-			path := copyStruct.sourcePath
-			targetPath := copyStruct.targetPath
-			_, err := w.filePorter.CopyFile(path, targetPath)
-			if err != nil {
-				logrus.Println("CopyFile: ", err)
+		case <-finished:
+			return
+		case <-progressTicker.C:
+			logrus.Infoln("文件复制进度", w.stats)
+		}
+	}
+}
+
+// 复制失败时按200ms、400ms...的间隔重试SYNC_COPY_RETRIES次
+func (w *RepoWorker) copyWithRetry(copyStruct *CopyJob) {
+	for attempt := 0; ; attempt++ {
+		written, err := w.filePorter.CopyFile(copyStruct.sourcePath, copyStruct.targetPath, copyStruct.checksum)
+		if err == nil {
+			if written < 0 {
+				w.stats.Skipped.Add(1)
+			} else {
+				w.stats.Copied.Add(1)
+				w.stats.Bytes.Add(written)
+			}
+			return
+		}
+		if attempt >= w.retries {
+			logrus.Errorln("复制文件失败", copyStruct.sourcePath, copyStruct.targetPath, err)
+			w.stats.Failed.Add(1)
+			// 数据库中的校验和在复制前已经写入，清空后下次同步按变化的文件重新写入并复制
+			if err := PGClearFileChecksum(copyStruct.uid); err != nil {
+				logrus.Errorln("清空文件校验和出错，下次同步不会重新复制", copyStruct.uid, err)
 			}
+			return
 		}
+		logrus.Warnln("复制文件出错，稍后重试", copyStruct.sourcePath, err)
+		time.Sleep((200 * time.Millisecond) << attempt)
 	}
 }
@@ -0,0 +1,292 @@
+package articles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"portal/models/repo"
+	"portal/services/githelper"
+
+	"github.com/pnnh/neutron/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+// 同步目录位于git仓库中时记录的仓库信息
+type gitState struct {
+	info       *githelper.GitInfo
+	pathPrefix string              // 同步目录相对于仓库根目录的路径，以/分隔，位于根目录时为空
+	repoFiles  map[string]struct{} // repo_files中已经记录的文件
+}
+
+// 读取同步目录的git信息，不是git仓库时返回nil，按普通目录同步
+func loadGitState(source *SyncSource) *gitState {
+	rootPath := source.RootPath
+	gitInfo, err := githelper.GitInfoGet(rootPath)
+	if err != nil {
+		logrus.Infoln("同步目录不是git仓库，不记录提交信息", rootPath, err)
+		return nil
+	}
+	// 检出标签时HEAD处于分离状态，按标签名记录分支
+	if source.Tag != "" {
+		gitInfo.Branch = source.Tag
+	}
+	state := &gitState{info: gitInfo}
+	gitRoot, err := filepath.EvalSymlinks(gitInfo.RootPath)
+	if err == nil {
+		var realRootPath string
+		realRootPath, err = filepath.EvalSymlinks(rootPath)
+		if err == nil {
+			state.pathPrefix, err = filepath.Rel(gitRoot, realRootPath)
+		}
+	}
+	if err != nil {
+		logrus.Warnln("计算同步目录在仓库中的路径出错，不记录提交信息", rootPath, err)
+		return nil
+	}
+	if state.pathPrefix == "." {
+		state.pathPrefix = ""
+	}
+	state.pathPrefix = filepath.ToSlash(state.pathPrefix)
+	state.repoFiles, err = repo.PGSelectRepoFileUids(gitInfo.FirstCommitId, gitInfo.Branch)
+	if err != nil {
+		logrus.Warnln("查询仓库文件记录出错", err)
+		state.repoFiles = make(map[string]struct{})
+	}
+	return state
+}
+
+// 按同步目录在仓库中的路径向上找到仓库根目录，不解析符号链接，与同步目录下的路径保持一致
+func (s *gitState) rootDir(rootPath string) string {
+	gitRoot := rootPath
+	if s.pathPrefix == "" {
+		return gitRoot
+	}
+	for range strings.Split(s.pathPrefix, "/") {
+		gitRoot = filepath.Dir(gitRoot)
+	}
+	return gitRoot
+}
+
+// 文件相对于仓库根目录的路径，以/开头
+func (w *ArticleWorker) relativePath(path string) string {
+	rel, err := filepath.Rel(w.rootPath, path)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	if w.git.pathPrefix != "" {
+		rel = w.git.pathPrefix + "/" + rel
+	}
+	return "/" + rel
+}
+
+// 记录文件在仓库中的路径和提交，已经记录过且未变化的文件只更新批次号
+func (w *ArticleWorker) saveRepoFile(uid, path, targetPath, checksum, mimeType string, isDir bool) {
+	if w.git == nil {
+		return
+	}
+	model := &repo.MtRepoFileModel{
+		Uid:             uid,
+		Branch:          w.git.info.Branch,
+		CommitId:        w.git.info.CommitId,
+		SrcPath:         path,
+		TargetPath:      targetPath,
+		Mime:            mimeType,
+		Checksum:        sql.NullString{String: checksum, Valid: checksum != ""},
+		Syncno:          sql.NullString{String: w.syncno, Valid: true},
+		RepoId:          sql.NullString{String: w.repoId, Valid: true},
+		RepoFirstCommit: sql.NullString{String: w.git.info.FirstCommitId, Valid: true},
+		RelativePath:    sql.NullString{String: w.relativePath(path), Valid: true},
+		IsDir:           sql.NullBool{Bool: isDir, Valid: true},
+	}
+	if err := repo.PGInsertOrUpdateRepoFile(model); err != nil {
+		logrus.Errorln("记录仓库文件出错", path, err)
+		return
+	}
+	w.git.repoFiles[uid] = struct{}{}
+}
+
+// SyncChanges 同步目录是git仓库且上次同步的提交是当前提交的祖先时，只同步两次提交之间变化的文件
+// 返回false时表示无法增量同步，需要全量同步
+func (w *ArticleWorker) SyncChanges(ctx context.Context) (bool, error) {
+	if w.git == nil || !w.git.info.IsClean {
+		return false, nil
+	}
+	syncInfo, err := repo.PGGetRepoSyncInfo(w.repoId, w.git.info.Branch)
+	if err != nil {
+		return false, fmt.Errorf("PGGetRepoSyncInfo: %w", err)
+	}
+	if syncInfo == nil || syncInfo.LastCommitId == "" || syncInfo.FirstCommitId != w.git.info.FirstCommitId {
+		return false, nil
+	}
+	headCommit := w.git.info.CommitId
+	if syncInfo.LastCommitId == headCommit {
+		logrus.Infoln("仓库没有新的提交，跳过同步", w.rootPath, headCommit)
+		return true, nil
+	}
+	if ok, err := githelper.GitCommitIsAncestor(w.rootPath, syncInfo.LastCommitId, headCommit); !ok {
+		logrus.Infoln("上次同步的提交不是当前提交的祖先，全量同步", syncInfo.LastCommitId, headCommit, err)
+		return false, nil
+	}
+	changes, err := githelper.GitDiffNameStatus(w.rootPath, syncInfo.LastCommitId, headCommit)
+	if err != nil {
+		return false, fmt.Errorf("GitDiffNameStatus: %w", err)
+	}
+
+	w.synced = loadSyncedFiles(w.parentPath)
+	pathSet := make(map[string]struct{}, len(changes))
+	removedFiles := make([]*syncedFile, 0)
+	for _, item := range changes {
+		relPath := item.Path
+		if w.git.pathPrefix != "" {
+			if !strings.HasPrefix(relPath, w.git.pathPrefix+"/") {
+				continue
+			}
+			relPath = strings.TrimPrefix(relPath, w.git.pathPrefix+"/")
+		}
+		fullPath := filepath.Join(w.rootPath, filepath.FromSlash(relPath))
+		// git只记录文件，删除整个目录后留下的目录记录由全量同步清理
+		if item.Status != "D" {
+			pathSet[w.unsyncedAncestor(fullPath)] = struct{}{}
+			continue
+		}
+		// 文件已经删除，无法读取frontmatter中的uid，按路径查找已同步的记录
+		if existing := w.syncedFileAt(fullPath); existing != nil {
+			removedFiles = append(removedFiles, existing)
+		}
+	}
+	paths := make([]string, 0, len(pathSet))
+	for path := range pathSet {
+		paths = append(paths, path)
+	}
+	// 按路径排序保证先同步上级目录，已经被上级目录包含的路径随目录一起同步
+	sort.Strings(paths)
+	compacted := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if n := len(compacted); n > 0 && strings.HasPrefix(path, compacted[n-1]+string(os.PathSeparator)) {
+			continue
+		}
+		compacted = append(compacted, path)
+	}
+	paths = compacted
+	logrus.Infoln("同步仓库变化的文件", syncInfo.LastCommitId, headCommit, len(paths), len(removedFiles))
+
+	if err := w.SyncPaths(ctx, paths); err != nil {
+		return true, err
+	}
+	if ctx.Err() != nil {
+		return true, nil
+	}
+	w.stats.Removed = len(removedFiles)
+	// 没有变化的文件不会被遍历，统一更新批次号，避免被当作旧批次的文件清理
+	removedUids := make([]string, 0, len(removedFiles))
+	for _, item := range removedFiles {
+		removedUids = append(removedUids, item.Uid)
+	}
+	if err := repo.PGTouchRepoFiles(w.git.info.FirstCommitId, w.git.info.Branch, w.syncno, removedUids); err != nil {
+		return true, fmt.Errorf("PGTouchRepoFiles: %w", err)
+	}
+	if w.stats.Failed > 0 {
+		logrus.Warnln("存在同步失败的文件，跳过清理已删除的文件", w.stats.Failed)
+		return true, nil
+	}
+	if !w.waitCopies() {
+		return true, nil
+	}
+	// 删除的文件来自git记录，不需要按比例检查
+	if len(removedFiles) > 0 {
+		mode, err := checkPruneMode()
+		if err == nil && mode != PruneModeOff {
+			err = w.pruneFiles(mode, removedFiles)
+		}
+		if err != nil {
+			return true, fmt.Errorf("清理已删除的文件出错: %w", err)
+		}
+	}
+	w.recordRepoSync()
+	return true, nil
+}
+
+// 新增文件所在的目录可能还没有同步过，此时改为同步最上层未同步的目录
+func (w *ArticleWorker) unsyncedAncestor(path string) string {
+	result := path
+	for dir := filepath.Dir(path); dir != w.rootPath && len(dir) > len(w.rootPath); dir = filepath.Dir(dir) {
+		if w.syncedFileAt(dir) == nil {
+			result = dir
+		}
+	}
+	return result
+}
+
+// 按父目录和文件名从同步目录逐级查找已同步且未删除的记录，frontmatter中指定了uid的文件也能找到
+func (w *ArticleWorker) syncedFileAt(path string) *syncedFile {
+	rel, err := filepath.Rel(w.rootPath, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	if w.syncedByName == nil {
+		w.syncedByName = make(map[string]*syncedFile, len(w.synced))
+		for _, item := range w.synced {
+			if !item.Removed {
+				w.syncedByName[item.Parent+"/"+item.Name] = item
+			}
+		}
+	}
+	parentUid := w.dirStatMap[w.rootPath].uid
+	var existing *syncedFile
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		existing = w.syncedByName[parentUid+"/"+name]
+		if existing == nil {
+			return nil
+		}
+		parentUid = existing.Uid
+	}
+	return existing
+}
+
+// 工作区没有未提交的修改且全部文件同步成功时记录本次同步的提交，下次同步从该提交开始比较
+func (w *ArticleWorker) recordRepoSync() {
+	if w.git == nil || w.ctx.Err() != nil {
+		return
+	}
+	if !w.git.info.IsClean {
+		logrus.Infoln("工作区存在未提交的修改，不记录同步的提交", w.rootPath)
+		return
+	}
+	if w.stats.Failed > 0 {
+		return
+	}
+	syncInfo, err := repo.PGGetRepoSyncInfo(w.repoId, w.git.info.Branch)
+	if err != nil {
+		logrus.Errorln("查询仓库同步记录出错", err)
+		return
+	}
+	model := &repo.MTRepoSyncModel{
+		Uid:           helpers.MustUuid(),
+		LastCommitId:  w.git.info.CommitId,
+		FirstCommitId: w.git.info.FirstCommitId,
+		Branch:        w.git.info.Branch,
+		RepoId:        w.repoId,
+		SourcePath:    w.rootPath,
+	}
+	if syncInfo != nil {
+		model.Uid = syncInfo.Uid
+	}
+	if err := repo.PGInsertOrUpdateRepoSyncInfo(model); err != nil {
+		logrus.Errorln("记录仓库同步的提交出错", err)
+	}
+}
+
+// 文件是否已经在repo_files中记录，不是git仓库时总是返回true
+func (w *ArticleWorker) repoFileSaved(uid string) bool {
+	if w.git == nil {
+		return true
+	}
+	_, ok := w.git.repoFiles[uid]
+	return ok
+}
@@ -0,0 +1,47 @@
+package articles
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncedFileAt(t *testing.T) {
+	rootPath := filepath.FromSlash("/repo/blog")
+	worker := &ArticleWorker{
+		rootPath:   rootPath,
+		dirStatMap: map[string]*dirStat{rootPath: {uid: "root"}},
+		synced: map[string]*syncedFile{
+			"d1": {Uid: "d1", Parent: "root", Name: "posts"},
+			// frontmatter中指定的uid与路径无关
+			"custom": {Uid: "custom", Parent: "d1", Name: "a.md"},
+			"n1":     {Uid: "n1", Parent: "d1", Name: "b.note"},
+			"n1i":    {Uid: "n1i", Parent: "n1", Name: "cover.png"},
+			"old":    {Uid: "old", Parent: "d1", Name: "gone.md", Removed: true},
+		},
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"posts", "d1"},
+		{"posts/a.md", "custom"},
+		{"posts/b.note", "n1"},
+		{"posts/b.note/cover.png", "n1i"},
+		{"posts/gone.md", ""},
+		{"posts/missing.md", ""},
+		{"drafts/a.md", ""},
+		{"..", ""},
+		{".", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := ""
+			if existing := worker.syncedFileAt(filepath.Join(rootPath, filepath.FromSlash(tt.path))); existing != nil {
+				got = existing.Uid
+			}
+			if got != tt.want {
+				t.Errorf("syncedFileAt(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package articles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"portal/services/githelper"
+
+	"github.com/sabhiram/go-gitignore"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// .polaris/ignore文件，publish和exclude都使用.gitignore的规则语法，路径相对于同步目录
+//
+//	publish:
+//	  - "posts/"
+//	exclude:
+//	  - "drafts/"
+//	  - "*.private.md"
+type polarisIgnoreFile struct {
+	Publish []string `yaml:"publish"` // 只发布匹配的文件，为空时发布全部文件
+	Exclude []string `yaml:"exclude"` // 不发布匹配的文件和目录
+}
+
+// 同步时的忽略规则，依次检查同步源配置、.gitignore和.polaris/ignore
+type ignoreRules struct {
+	rootPath  string
+	source    *SyncSource
+	gitIgnore *githelper.GitIgnoreHelper
+	publish   *ignore.GitIgnore
+	exclude   *ignore.GitIgnore
+}
+
+// .gitignore从git仓库根目录开始查找，不是git仓库时从同步目录开始查找
+func loadIgnoreRules(source *SyncSource, gitRoot string) (*ignoreRules, error) {
+	if gitRoot == "" {
+		gitRoot = source.RootPath
+	}
+	rules := &ignoreRules{
+		rootPath:  source.RootPath,
+		source:    source,
+		gitIgnore: githelper.NewGitIgnoreHelper(gitRoot),
+	}
+	ignoreFilePath := filepath.Join(source.RootPath, ".polaris", "ignore")
+	data, err := os.ReadFile(ignoreFilePath)
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取.polaris/ignore文件失败: %w", err)
+	}
+	ignoreFile := &polarisIgnoreFile{}
+	if err := yaml.Unmarshal(data, ignoreFile); err != nil {
+		return nil, fmt.Errorf("解析.polaris/ignore文件出错: %w", err)
+	}
+	if len(ignoreFile.Publish) > 0 {
+		rules.publish = ignore.CompileIgnoreLines(ignoreFile.Publish...)
+	}
+	if len(ignoreFile.Exclude) > 0 {
+		rules.exclude = ignore.CompileIgnoreLines(ignoreFile.Exclude...)
+	}
+	return rules, nil
+}
+
+// 路径是否需要忽略，配置了publish时不匹配的文件也会忽略，目录总是继续遍历
+func (r *ignoreRules) isIgnored(path string, isDir bool) bool {
+	if r.source.IsIgnored(path) {
+		return true
+	}
+	ignored, err := r.gitIgnore.MatchsPath(path)
+	if isDir {
+		ignored, err = r.gitIgnore.MatchsDir(path)
+	}
+	if err != nil {
+		logrus.Warnln("匹配.gitignore规则出错", path, err)
+	}
+	if ignored {
+		return true
+	}
+	if r.publish == nil && r.exclude == nil {
+		return false
+	}
+	relativePath, err := filepath.Rel(r.rootPath, path)
+	if err != nil {
+		return false
+	}
+	relativePath = filepath.ToSlash(relativePath)
+	if isDir {
+		relativePath += "/"
+	}
+	if r.exclude != nil && r.exclude.MatchesPath(relativePath) {
+		return true
+	}
+	if r.publish != nil && !isDir && !r.publish.MatchesPath(relativePath) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package articles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	rootPath := t.TempDir()
+	writeTestFile(t, filepath.Join(rootPath, ".polaris", "ignore"), `publish:
+  - "posts/"
+  - "*.note/"
+exclude:
+  - "drafts/"
+  - "*.private.md"
+`)
+	writeTestFile(t, filepath.Join(rootPath, ".gitignore"), "build/\n*.log\n")
+	source := &SyncSource{RootPath: rootPath, Ignore: []string{"*.tmp", "/posts/skip.md"}}
+	rules, err := loadIgnoreRules(source, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"posts/a.md", false, false},
+		{"posts/2024/b.md", false, false},
+		{"posts/a.private.md", false, true},
+		{"posts/drafts", true, true},
+		{"posts/drafts/c.md", false, true},
+		{"drafts", true, true},
+		{"readme.md", false, true},
+		{"other/d.md", false, true},
+		{"other/x.note", true, false},
+		{"other/x.note/index.md", false, false},
+		// 目录不受publish限制，继续遍历其中的文件
+		{"other", true, false},
+		{"build", true, true},
+		{"posts/debug.log", false, true},
+		{"posts/e.tmp", false, true},
+		{"posts/skip.md", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path := filepath.Join(rootPath, filepath.FromSlash(tt.path))
+			if got := rules.isIgnored(path, tt.isDir); got != tt.want {
+				t.Errorf("isIgnored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoreRulesWithoutPolarisFile(t *testing.T) {
+	rootPath := t.TempDir()
+	rules, err := loadIgnoreRules(&SyncSource{RootPath: rootPath}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.publish != nil || rules.exclude != nil {
+		t.Fatal("没有.polaris/ignore时不应有publish和exclude规则")
+	}
+	for _, path := range []string{"a.md", "posts/b.md", "drafts/c.private.md"} {
+		if rules.isIgnored(filepath.Join(rootPath, path), false) {
+			t.Errorf("isIgnored(%q) = true, want false", path)
+		}
+	}
+}
+
+func TestIgnoreRulesInvalidFile(t *testing.T) {
+	rootPath := t.TempDir()
+	writeTestFile(t, filepath.Join(rootPath, ".polaris", "ignore"), "publish: [\n")
+	if _, err := loadIgnoreRules(&SyncSource{RootPath: rootPath}, ""); err == nil {
+		t.Fatal("解析出错的.polaris/ignore应当返回错误")
+	}
+}
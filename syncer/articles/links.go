@@ -0,0 +1,137 @@
+package articles
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// [text](target "title") 和 ![alt](target)
+	markdownLinkRegexp = regexp.MustCompile(`(!?\[[^\]]*\]\(\s*)(<[^>]*>|[^\s)]+)`)
+	// [id]: target
+	referenceLinkRegexp = regexp.MustCompile(`^(\s{0,3}\[[^\]]+\]:\s*)(<[^>]*>|\S+)`)
+	// <img src="target"> 和 <a href="target">
+	htmlLinkRegexp = regexp.MustCompile(`(<(?:img|a|source|video|audio)\b[^>]*?\b(?:src|href)\s*=\s*")([^"]*)(")`)
+)
+
+// 把文章中指向同步目录内文件的相对链接和图片改写为存储地址，代码块中的内容不处理
+func (w *ArticleWorker) rewriteLinks(note *noteInfo) string {
+	lines := strings.Split(note.body, "\n")
+	fence := ""
+	for index, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+		lines[index] = w.rewriteLine(note, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 行内代码以反引号分隔，只处理反引号之外的部分
+func (w *ArticleWorker) rewriteLine(note *noteInfo, line string) string {
+	if !strings.Contains(line, "](") && !strings.Contains(line, "]:") && !strings.Contains(line, "<") {
+		return line
+	}
+	parts := strings.Split(line, "`")
+	for index := 0; index < len(parts); index += 2 {
+		part := parts[index]
+		part = referenceLinkRegexp.ReplaceAllStringFunc(part, func(match string) string {
+			groups := referenceLinkRegexp.FindStringSubmatch(match)
+			return groups[1] + w.resolveLink(note, groups[2])
+		})
+		part = markdownLinkRegexp.ReplaceAllStringFunc(part, func(match string) string {
+			groups := markdownLinkRegexp.FindStringSubmatch(match)
+			return groups[1] + w.resolveLink(note, groups[2])
+		})
+		part = htmlLinkRegexp.ReplaceAllStringFunc(part, func(match string) string {
+			groups := htmlLinkRegexp.FindStringSubmatch(match)
+			return groups[1] + w.resolveLink(note, groups[2]) + groups[3]
+		})
+		parts[index] = part
+	}
+	return strings.Join(parts, "`")
+}
+
+// 解析相对链接，无法解析时保留原链接并记录
+func (w *ArticleWorker) resolveLink(note *noteInfo, target string) string {
+	rawTarget := strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	if rawTarget == "" || strings.HasPrefix(rawTarget, "#") || strings.HasPrefix(rawTarget, "//") ||
+		strings.Contains(rawTarget, "://") || strings.HasPrefix(rawTarget, "mailto:") ||
+		strings.HasPrefix(rawTarget, "tel:") || strings.HasPrefix(rawTarget, "data:") {
+		return target
+	}
+	linkPath, fragment := rawTarget, ""
+	if index := strings.IndexAny(linkPath, "?#"); index >= 0 {
+		linkPath, fragment = linkPath[:index], linkPath[index:]
+	}
+	storageUrl, err := w.resolveLinkPath(note, linkPath)
+	if err != nil {
+		w.brokenLinks = append(w.brokenLinks, &brokenLink{file: note.filePath, target: rawTarget, reason: err.Error()})
+		return target
+	}
+	return storageUrl + fragment
+}
+
+func (w *ArticleWorker) resolveLinkPath(note *noteInfo, linkPath string) (string, error) {
+	unescaped, err := url.PathUnescape(linkPath)
+	if err == nil {
+		linkPath = unescaped
+	}
+	fullPath := filepath.Join(filepath.Dir(note.filePath), filepath.FromSlash(linkPath))
+	if strings.HasPrefix(linkPath, "/") {
+		fullPath = filepath.Join(w.rootPath, filepath.FromSlash(linkPath))
+	}
+	if !strings.HasPrefix(fullPath, w.rootPath+string(os.PathSeparator)) {
+		return "", fmt.Errorf("链接指向同步目录之外")
+	}
+	if storageUrl, ok := w.linkUrls[fullPath]; ok {
+		return storageUrl, nil
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("文件不存在")
+	}
+	// 指向.note目录的链接改为指向其中的index.md
+	targetPath := fullPath
+	if info.IsDir() {
+		targetPath = filepath.Join(fullPath, "index.md")
+		if !isNotePath(fullPath, true) {
+			return "", fmt.Errorf("链接指向目录")
+		}
+	}
+	storageUrl, err := w.storageUrlOf(targetPath)
+	if err != nil {
+		return "", err
+	}
+	w.linkUrls[fullPath] = storageUrl
+	return storageUrl, nil
+}
+
+// 文章中无法解析的链接
+type brokenLink struct {
+	file   string
+	target string
+	reason string
+}
+
+func (w *ArticleWorker) reportBrokenLinks() {
+	for _, item := range w.brokenLinks {
+		logrus.Warnln("无效的链接", item.file, item.target, item.reason)
+	}
+	w.stats.BrokenLinks += len(w.brokenLinks)
+	w.brokenLinks = nil
+}
@@ -0,0 +1,96 @@
+package articles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 链接的存储地址预先放入linkUrls，不需要计算uid
+func newLinkTestWorker(t *testing.T) (*ArticleWorker, *noteInfo) {
+	rootPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootPath, "docs", "plain"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	worker := &ArticleWorker{
+		rootPath: rootPath,
+		linkUrls: map[string]string{
+			filepath.Join(rootPath, "docs", "a.png"):       "storage://d1/a1/a.png",
+			filepath.Join(rootPath, "docs", "b.md"):        "storage://d1/b1/b.md",
+			filepath.Join(rootPath, "images", "c d.png"):   "storage://d2/c1/c d.png",
+			filepath.Join(rootPath, "docs", "sub", "e.md"): "storage://d3/e1/e.md",
+		},
+	}
+	note := &noteInfo{filePath: filepath.Join(rootPath, "docs", "post.md")}
+	return worker, note
+}
+
+func TestRewriteLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"image", "![alt](a.png)", "![alt](storage://d1/a1/a.png)"},
+		{"link with title", `[b](./b.md "title")`, `[b](storage://d1/b1/b.md "title")`},
+		{"parent dir", "[c](../images/c%20d.png)", "[c](storage://d2/c1/c d.png)"},
+		{"root relative", "[c](/images/c%20d.png)", "[c](storage://d2/c1/c d.png)"},
+		{"angle brackets", "[c](<../images/c d.png>)", "[c](storage://d2/c1/c d.png)"},
+		{"fragment", "[b](b.md#intro)", "[b](storage://d1/b1/b.md#intro)"},
+		{"query", "[b](b.md?x=1)", "[b](storage://d1/b1/b.md?x=1)"},
+		{"reference link", "[id]: sub/e.md", "[id]: storage://d3/e1/e.md"},
+		{"html image", `<img class="x" src="a.png">`, `<img class="x" src="storage://d1/a1/a.png">`},
+		{"html anchor", `<a href="b.md">b</a>`, `<a href="storage://d1/b1/b.md">b</a>`},
+		{"several links", "[b](b.md) and ![a](a.png)", "[b](storage://d1/b1/b.md) and ![a](storage://d1/a1/a.png)"},
+		{"inline code", "`[b](b.md)` [b](b.md)", "`[b](b.md)` [b](storage://d1/b1/b.md)"},
+		{"absolute url", "[x](https://example.com/a.png)", "[x](https://example.com/a.png)"},
+		{"protocol relative", "[x](//example.com/a.png)", "[x](//example.com/a.png)"},
+		{"anchor only", "[x](#section)", "[x](#section)"},
+		{"mailto", "[x](mailto:a@b.c)", "[x](mailto:a@b.c)"},
+		{"data url", "![x](data:image/png;base64,AAAA)", "![x](data:image/png;base64,AAAA)"},
+		{"plain text", "no links here", "no links here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker, note := newLinkTestWorker(t)
+			if got := worker.rewriteLine(note, tt.line); got != tt.want {
+				t.Errorf("rewriteLine(%q)\n got: %s\nwant: %s", tt.line, got, tt.want)
+			}
+			if len(worker.brokenLinks) != 0 {
+				t.Errorf("rewriteLine(%q) 不应有无效链接: %s", tt.line, worker.brokenLinks[0].reason)
+			}
+		})
+	}
+}
+
+func TestResolveLinkBroken(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"missing file", "missing.png"},
+		{"outside root", "../../outside.md"},
+		{"root relative outside", "/../outside.md"},
+		{"plain directory", "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker, note := newLinkTestWorker(t)
+			if got := worker.resolveLink(note, tt.target); got != tt.target {
+				t.Errorf("resolveLink(%q) = %q，无效链接应保持不变", tt.target, got)
+			}
+			if len(worker.brokenLinks) != 1 || worker.brokenLinks[0].target != tt.target {
+				t.Errorf("resolveLink(%q) 应记录一个无效链接，got %d", tt.target, len(worker.brokenLinks))
+			}
+		})
+	}
+}
+
+func TestRewriteLinksSkipsFencedCode(t *testing.T) {
+	worker, note := newLinkTestWorker(t)
+	note.body = "![a](a.png)\n```md\n![a](a.png)\n```\n~~~\n[b](b.md)\n~~~\n[b](b.md)"
+	want := "![a](storage://d1/a1/a.png)\n```md\n![a](a.png)\n```\n~~~\n[b](b.md)\n~~~\n[b](storage://d1/b1/b.md)"
+	if got := worker.rewriteLinks(note); got != want {
+		t.Errorf("rewriteLinks\n got: %q\nwant: %q", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package articles
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// 数据库中已经同步过的文件，用于判断文件是否发生变化
+type syncedFile struct {
+	Uid      string `db:"uid"`
+	Parent   string `db:"parent"`
+	Name     string `db:"name"`
+	Checksum string `db:"checksum"`
+	Url      string `db:"url"`
+	Syncno   string `db:"syncno"`
+	Removed  bool   `db:"removed"` // 已经标记为删除
+}
+
+// SyncStats 一次同步的文件数量统计
+type SyncStats struct {
+	Added       int
+	Changed     int
+	Unchanged   int
+	Removed     int // 数据库中存在但本次全量同步没有遍历到的文件
+	Failed      int
+	BrokenLinks int // 文章中无法解析的相对链接
+}
+
+func (s *SyncStats) String() string {
+	return fmt.Sprintf("added=%d changed=%d unchanged=%d removed=%d failed=%d broken_links=%d",
+		s.Added, s.Changed, s.Unchanged, s.Removed, s.Failed, s.BrokenLinks)
+}
+
+// PGSelectSyncedFiles 查询同步目录下已有的全部文件，按uid索引
+func PGSelectSyncedFiles(parentPath string) (map[string]*syncedFile, error) {
+	sqlText := `select uid::text as uid, COALESCE(parent::text, '') as parent, COALESCE(name, '') as name,
+    COALESCE(checksum, '') as checksum, COALESCE(url, '') as url, COALESCE(syncno, '') as syncno,
+    removed_time is not null as removed
+from community.files where path::text like :path_prefix;`
+
+	sqlParams := map[string]interface{}{"path_prefix": parentPath + ".%"}
+	var sqlResults []*syncedFile
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+
+	result := make(map[string]*syncedFile, len(sqlResults))
+	for _, item := range sqlResults {
+		result[item.Uid] = item
+	}
+	return result, nil
+}
+
+// PGSelectFilePath 查询目录的path，目录不存在时返回空字符串
+func PGSelectFilePath(uid string) (string, error) {
+	sqlText := `select path::text as path from community.files where uid = :uid;`
+
+	sqlParams := map[string]interface{}{"uid": uid}
+	var sqlResults []struct {
+		Path string `db:"path"`
+	}
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return "", fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return "", fmt.Errorf("StructScan: %w", err)
+	}
+	if len(sqlResults) == 0 {
+		return "", nil
+	}
+	return sqlResults[0].Path, nil
+}
+
+// 每条语句更新的文件数量
+const touchBatchSize = 500
+
+// PGTouchSyncedFiles 未变化的文件不再重新写入，只把文件和仓库文件记录的批次号更新为本次同步的批次号
+func PGTouchSyncedFiles(syncno string, uids []string) error {
+	sqlTexts := []string{
+		`update community.files set syncno = :syncno
+where uid::text = any(string_to_array(:uids, ',')) and syncno is distinct from :syncno;`,
+		`update repo_files set syncno = :syncno
+where uid::text = any(string_to_array(:uids, ',')) and syncno is distinct from :syncno;`,
+	}
+
+	for start := 0; start < len(uids); start += touchBatchSize {
+		end := min(start+touchBatchSize, len(uids))
+		sqlParams := map[string]interface{}{
+			"syncno": syncno,
+			"uids":   strings.Join(uids[start:end], ","),
+		}
+		for _, sqlText := range sqlTexts {
+			if _, err := datastore.NamedExec(sqlText, sqlParams); err != nil {
+				return fmt.Errorf("PGTouchSyncedFiles: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// PGClearFileChecksum 文件复制失败时清空校验和，下次同步时与源文件的校验和不同，重新写入并复制
+func PGClearFileChecksum(uid string) error {
+	sqlText := `update community.files set checksum = null where uid = :uid;`
+
+	sqlParams := map[string]interface{}{"uid": uid}
+	if _, err := datastore.NamedExec(sqlText, sqlParams); err != nil {
+		return fmt.Errorf("PGClearFileChecksum: %w", err)
+	}
+	return nil
+}
+
+// 加载已同步文件失败时按全部文件都是新增处理
+func loadSyncedFiles(parentPath string) map[string]*syncedFile {
+	files, err := PGSelectSyncedFiles(parentPath)
+	if err != nil {
+		logrus.Warnln("查询已同步文件出错，全部文件重新同步", err)
+		return make(map[string]*syncedFile)
+	}
+	return files
+}
@@ -0,0 +1,184 @@
+package articles
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bizarticles "portal/business/articles"
+	"portal/services/base58"
+
+	"github.com/adrg/frontmatter"
+	"github.com/pnnh/neutron/helpers"
+	"github.com/pnnh/neutron/helpers/jsonmap"
+	"github.com/pnnh/neutron/services/checksum"
+	"github.com/sirupsen/logrus"
+)
+
+// 从frontmatter中解析出的文章信息
+type noteInfo struct {
+	matter   *bizarticles.MTNoteMatter
+	body     string
+	filePath string // 包含frontmatter的文件，.note目录为其中的index.md
+	checksum string
+}
+
+// .md文件和.note目录作为文章同步，.note目录中的index.md是文章内容，本身不再作为文章
+func isNotePath(path string, isDir bool) bool {
+	if isDir {
+		return strings.HasSuffix(filepath.Base(path), ".note")
+	}
+	if strings.ToLower(filepath.Ext(path)) != ".md" {
+		return false
+	}
+	return !(filepath.Base(path) == "index.md" && strings.HasSuffix(filepath.Dir(path), ".note"))
+}
+
+// 读取文章的frontmatter，不是文章或.note目录中没有index.md时返回nil
+func readNote(path string, isDir bool) (*noteInfo, error) {
+	if !isNotePath(path, isDir) {
+		return nil, nil
+	}
+	filePath := path
+	if isDir {
+		filePath = filepath.Join(path, "index.md")
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取文章失败: %w", err)
+	}
+	matter := &bizarticles.MTNoteMatter{}
+	restData, err := frontmatter.Parse(bytes.NewReader(data), matter)
+	if err != nil {
+		return nil, fmt.Errorf("解析文章元数据失败: %w", err)
+	}
+	sum, err := checksum.CalcSha256(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("计算文件校验和失败: %w", err)
+	}
+	return &noteInfo{matter: matter, body: string(restData), filePath: filePath, checksum: sum}, nil
+}
+
+// frontmatter中配置了uid时使用该uid，文件移动或改名后仍然是同一篇文章
+func (n *noteInfo) stableUid() string {
+	if n == nil {
+		return ""
+	}
+	uid := strings.ToLower(strings.TrimSpace(n.matter.Uid))
+	if !helpers.IsUuid(uid) {
+		return ""
+	}
+	return uid
+}
+
+// 计算文件或目录的UID，文章优先使用frontmatter中的uid
+func (w *ArticleWorker) resolveUid(path string, isDir bool) (string, error) {
+	note, err := readNote(path, isDir)
+	if err != nil {
+		logrus.Warnln("读取文章元数据出错", path, err)
+	}
+	if uid := note.stableUid(); uid != "" {
+		return uid, nil
+	}
+	return w.calcFileUid(path)
+}
+
+// 文件在存储中的路径，父目录UID和文件UID转换为base58，保留文件扩展名
+func storageTargetPath(parentUid, uid, fileName string) (string, error) {
+	targetParentDir, err := base58.UuidToBase58(parentUid)
+	if err != nil {
+		return "", fmt.Errorf("转换父目录UID失败: %w", err)
+	}
+	targetSelfName, err := base58.UuidToBase58(uid)
+	if err != nil {
+		return "", fmt.Errorf("转换文件UID失败: %w", err)
+	}
+	if !strings.HasPrefix(fileName, ".") {
+		extName := filepath.Ext(fileName)
+		if extName != "" {
+			targetSelfName += extName
+		}
+	}
+	return fmt.Sprintf("%s/%s", targetParentDir, targetSelfName), nil
+}
+
+// 同步目录中文件同步后的存储地址，文件不存在、不在同步目录中或被忽略时返回错误
+func (w *ArticleWorker) storageUrlOf(path string) (string, error) {
+	path = filepath.Clean(path)
+	if !strings.HasPrefix(path, w.rootPath+string(os.PathSeparator)) {
+		return "", fmt.Errorf("路径不在同步目录中")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("文件不存在: %w", err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("路径是目录")
+	}
+	if w.inIgnoredDir(path) || w.ignore.isIgnored(path, false) {
+		return "", fmt.Errorf("文件被忽略，不会同步")
+	}
+	uid, err := w.resolveUid(path, false)
+	if err != nil {
+		return "", err
+	}
+	parentStat := w.ensureDirStat(filepath.Dir(path))
+	targetPath, err := storageTargetPath(parentStat.uid, uid, filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	return "storage://" + targetPath, nil
+}
+
+// 封面为相对路径时解析为存储地址，相对于文章所在目录，以/开头时相对于同步目录
+func (w *ArticleWorker) resolveCover(note *noteInfo) string {
+	cover := strings.TrimSpace(note.matter.Cover)
+	if cover == "" || strings.Contains(cover, "://") || strings.HasPrefix(cover, "data:") {
+		return cover
+	}
+	coverPath := filepath.Join(filepath.Dir(note.filePath), filepath.FromSlash(cover))
+	if strings.HasPrefix(cover, "/") {
+		coverPath = filepath.Join(w.rootPath, filepath.FromSlash(cover))
+	}
+	storageUrl, err := w.storageUrlOf(coverPath)
+	if err != nil {
+		logrus.Warnln("文章封面无效", note.filePath, cover, err)
+		return ""
+	}
+	return storageUrl
+}
+
+// 用frontmatter中的信息覆盖文章的默认值，草稿保持未发布状态
+func (w *ArticleWorker) setNoteFields(dataRow *jsonmap.JsonMap, note *noteInfo) {
+	matter := note.matter
+	dataRow.SetInt("article", 1)
+	dataRow.SetString("header", "MTNote")
+	if strings.TrimSpace(note.body) != "" {
+		dataRow.SetString("body", w.rewriteLinks(note))
+	}
+	dataRow.SetString("description", matter.Description)
+	dataRow.SetString("keywords", matter.Keywords)
+	dataRow.SetNullString("cover", w.resolveCover(note))
+	if matter.Lang != "" {
+		dataRow.SetString("lang", matter.Lang)
+	}
+	channel := matter.Channel
+	if channel == "" {
+		channel = matter.Chan
+	}
+	if channel != "" {
+		if helpers.IsUuid(channel) {
+			dataRow.SetNullString("channel", channel)
+		} else {
+			logrus.Warnln("文章频道不是有效的uid", note.filePath, channel)
+		}
+	}
+	if matter.Draft {
+		dataRow.SetInt("status", 0)
+	}
+}
@@ -0,0 +1,111 @@
+package articles
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"portal/services/confighelper"
+)
+
+// PlanItem 同步计划中的一个文件或目录
+type PlanItem struct {
+	Uid       string `json:"uid"`
+	Path      string `json:"path,omitempty"` // 相对于同步目录，待删除的文件没有
+	Name      string `json:"name"`
+	Parent    string `json:"parent"`
+	Directory bool   `json:"directory,omitempty"`
+	Checksum  string `json:"checksum,omitempty"`
+	OldName   string `json:"old_name,omitempty"`
+	OldParent string `json:"old_parent,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+// SyncPlan 一个同步源的同步计划，只比较文件和数据库记录，不写数据库和存储
+type SyncPlan struct {
+	Source      string      `json:"source"`
+	RootPath    string      `json:"root_path"`
+	Creates     []*PlanItem `json:"creates"`
+	Updates     []*PlanItem `json:"updates"` // 内容变化
+	Moves       []*PlanItem `json:"moves"`   // 父目录或文件名变化，内容也可能变化
+	Deletes     []*PlanItem `json:"deletes"` // 按SYNC_PRUNE_MODE处理，off时为空
+	Unchanged   int         `json:"unchanged"`
+	BrokenLinks int         `json:"broken_links"`
+	PruneMode   string      `json:"prune_mode"`
+	Warnings    []string    `json:"warnings,omitempty"`
+}
+
+// Plan 遍历同步目录，与数据库中已有的记录比较得出同步计划，不执行其中的任何操作
+func (w *ArticleWorker) Plan(ctx context.Context) (*SyncPlan, error) {
+	w.ctx = ctx
+	pruneMode, err := checkPruneMode()
+	if err != nil {
+		return nil, err
+	}
+	w.synced, err = PGSelectSyncedFiles(w.parentPath)
+	if err != nil {
+		return nil, fmt.Errorf("PGSelectSyncedFiles: %w", err)
+	}
+	w.plan = &SyncPlan{
+		Source:    w.source.Name,
+		RootPath:  w.rootPath,
+		Creates:   make([]*PlanItem, 0),
+		Updates:   make([]*PlanItem, 0),
+		Moves:     make([]*PlanItem, 0),
+		Deletes:   make([]*PlanItem, 0),
+		PruneMode: pruneMode,
+	}
+	if err := filepath.Walk(w.rootPath, w.visitFile); err != nil {
+		return nil, fmt.Errorf("error walking the path %s: %w", w.rootPath, err)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	w.reportBrokenLinks()
+	plan := w.plan
+	plan.Unchanged = w.stats.Unchanged
+	plan.BrokenLinks = w.stats.BrokenLinks
+
+	total := 0
+	for uid, item := range w.synced {
+		if item.Removed {
+			continue
+		}
+		total += 1
+		if _, ok := w.visited[uid]; ok || pruneMode == PruneModeOff {
+			continue
+		}
+		plan.Deletes = append(plan.Deletes, &PlanItem{Uid: uid, Name: item.Name, Parent: item.Parent, Url: item.Url})
+	}
+	if w.repoIdGenerated {
+		plan.Warnings = append(plan.Warnings, "repo.yml中没有REPOID，同步时会生成并写入，计划中的uid与实际同步时不同")
+	}
+	maxRatio := confighelper.GetFloat("SYNC_PRUNE_MAX_RATIO", 0.3)
+	if total > 0 && float64(len(plan.Deletes))/float64(total) > maxRatio {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("待删除文件%d个，超过已有文件%d个的%.0f%%，同步时会放弃清理",
+			len(plan.Deletes), total, maxRatio*100))
+	}
+	for _, items := range [][]*PlanItem{plan.Creates, plan.Updates, plan.Moves} {
+		sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	}
+	sort.Slice(plan.Deletes, func(i, j int) bool { return plan.Deletes[i].Uid < plan.Deletes[j].Uid })
+	return plan, nil
+}
+
+// 按数据库中已有的记录把文件归入新建、更新或移动，已标记删除的文件重新出现时按新建处理
+func (w *ArticleWorker) addPlanItem(existing *syncedFile, path string, item *PlanItem) {
+	if relativePath, err := filepath.Rel(w.rootPath, path); err == nil {
+		item.Path = filepath.ToSlash(relativePath)
+	}
+	switch {
+	case existing == nil || existing.Removed:
+		w.plan.Creates = append(w.plan.Creates, item)
+	case existing.Parent != item.Parent || existing.Name != item.Name:
+		item.OldName = existing.Name
+		item.OldParent = existing.Parent
+		w.plan.Moves = append(w.plan.Moves, item)
+	default:
+		w.plan.Updates = append(w.plan.Updates, item)
+	}
+}
@@ -0,0 +1,181 @@
+package articles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portal/services/confighelper"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/services/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// 清理已删除文件的方式
+const (
+	PruneModeOff    = "off"    // 不处理
+	PruneModeMark   = "mark"   // 标记为已删除并取消发布，保留数据和存储中的文件
+	PruneModeDelete = "delete" // 删除数据库记录和存储中的文件
+)
+
+func checkPruneMode() (string, error) {
+	mode, ok := config.GetConfigurationString("SYNC_PRUNE_MODE")
+	if !ok || mode == "" {
+		return PruneModeMark, nil
+	}
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode != PruneModeOff && mode != PruneModeMark && mode != PruneModeDelete {
+		return "", fmt.Errorf("不支持的SYNC_PRUNE_MODE: %s", mode)
+	}
+	return mode, nil
+}
+
+// PGSelectStaleFiles 查询同步目录下批次号不是本次批次、且尚未标记删除的文件
+func PGSelectStaleFiles(parentPath string, syncno string) ([]*syncedFile, error) {
+	sqlText := `select uid::text as uid, COALESCE(parent::text, '') as parent, COALESCE(name, '') as name,
+    COALESCE(checksum, '') as checksum, COALESCE(url, '') as url, COALESCE(syncno, '') as syncno,
+    false as removed
+from community.files where path::text like :path_prefix and syncno is distinct from :syncno and removed_time is null;`
+
+	sqlParams := map[string]interface{}{"path_prefix": parentPath + ".%", "syncno": syncno}
+	var sqlResults []*syncedFile
+
+	rows, err := datastore.NamedQuery(sqlText, sqlParams)
+	if err != nil {
+		return nil, fmt.Errorf("NamedQuery: %w", err)
+	}
+	if err = sqlx.StructScan(rows, &sqlResults); err != nil {
+		return nil, fmt.Errorf("StructScan: %w", err)
+	}
+	return sqlResults, nil
+}
+
+// PGMarkFilesRemoved 标记文件已删除，同时取消发布对应的文章和图片
+func PGMarkFilesRemoved(uids []string) error {
+	return pgExecFilesBatch([]string{
+		`update community.files set status = 0, removed_time = now()
+where uid::text = any(string_to_array(:uids, ','));`,
+		`update community.articles set status = 0 where uid::text = any(string_to_array(:uids, ','));`,
+		`update community.images set status = 0 where uid::text = any(string_to_array(:uids, ','));`,
+	}, uids)
+}
+
+// PGDeleteFiles 删除文件及对应的文章和图片记录
+func PGDeleteFiles(uids []string) error {
+	return pgExecFilesBatch([]string{
+		`delete from community.articles where uid::text = any(string_to_array(:uids, ','));`,
+		`delete from community.images where uid::text = any(string_to_array(:uids, ','));`,
+		`delete from community.files where uid::text = any(string_to_array(:uids, ','));`,
+	}, uids)
+}
+
+// 每批文件在一个事务中执行全部语句
+func pgExecFilesBatch(sqlTexts []string, uids []string) error {
+	for start := 0; start < len(uids); start += touchBatchSize {
+		end := min(start+touchBatchSize, len(uids))
+		if err := pgExecFilesTx(sqlTexts, strings.Join(uids[start:end], ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pgExecFilesTx(sqlTexts []string, uids string) (err error) {
+	sqlTx, err := datastore.NewTranscation()
+	if err != nil {
+		return fmt.Errorf("NewTranscation: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := sqlTx.Rollback(); rollbackErr != nil {
+				err = fmt.Errorf("%w\nRollback: %v", err, rollbackErr)
+			}
+		}
+	}()
+	for _, sqlText := range sqlTexts {
+		rows, err := sqlTx.NamedQuery(sqlText, map[string]interface{}{"uids": uids})
+		if err != nil {
+			return fmt.Errorf("NamedQuery: %w", err)
+		}
+		if err = rows.Close(); err != nil {
+			return fmt.Errorf("rows.Close: %w", err)
+		}
+	}
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("Commit: %w", err)
+	}
+	return nil
+}
+
+// 全量同步成功后处理数据库中存在、但本次没有遍历到的文件
+// 待处理的文件占比超过SYNC_PRUNE_MAX_RATIO时放弃，避免源目录挂载失败等情况下误删
+func (w *ArticleWorker) prune() error {
+	mode, err := checkPruneMode()
+	if err != nil || mode == PruneModeOff {
+		return err
+	}
+
+	staleFiles, err := PGSelectStaleFiles(w.parentPath, w.syncno)
+	if err != nil {
+		return fmt.Errorf("PGSelectStaleFiles: %w", err)
+	}
+	if len(staleFiles) == 0 {
+		return nil
+	}
+	maxRatio := confighelper.GetFloat("SYNC_PRUNE_MAX_RATIO", 0.3)
+	total := 0
+	for _, item := range w.synced {
+		if !item.Removed {
+			total += 1
+		}
+	}
+	if total > 0 && float64(len(staleFiles))/float64(total) > maxRatio {
+		return fmt.Errorf("待删除文件%d个，超过已有文件%d个的%.0f%%，放弃清理", len(staleFiles), total, maxRatio*100)
+	}
+
+	return w.pruneFiles(mode, staleFiles)
+}
+
+// 按清理方式标记或删除指定的文件
+func (w *ArticleWorker) pruneFiles(mode string, staleFiles []*syncedFile) error {
+	uids := make([]string, 0, len(staleFiles))
+	for _, item := range staleFiles {
+		uids = append(uids, item.Uid)
+	}
+	if mode == PruneModeMark {
+		if err := PGMarkFilesRemoved(uids); err != nil {
+			return fmt.Errorf("PGMarkFilesRemoved: %w", err)
+		}
+		logrus.Infoln("已标记删除的文件", len(uids))
+		return nil
+	}
+
+	if err := PGDeleteFiles(uids); err != nil {
+		return fmt.Errorf("PGDeleteFiles: %w", err)
+	}
+	for _, item := range staleFiles {
+		if !strings.HasPrefix(item.Url, "storage://") {
+			continue
+		}
+		if err := w.repoWorker.RemoveTarget(strings.TrimPrefix(item.Url, "storage://")); err != nil {
+			logrus.Warnln("删除存储中的文件出错", item.Url, err)
+		}
+	}
+	logrus.Infoln("已删除的文件", len(uids))
+	return nil
+}
+
+// RemoveTarget 删除存储中的目标文件，文件不存在时忽略
+func (w *RepoWorker) RemoveTarget(targetPath string) error {
+	fullTargetPath := filepath.Join(w.filePorter.targetRootPath, string(os.PathSeparator), targetPath)
+	if !strings.HasPrefix(fullTargetPath, filepath.Clean(w.filePorter.targetRootPath)+string(os.PathSeparator)) {
+		return fmt.Errorf("目标路径不在存储目录中: %s", targetPath)
+	}
+	if err := os.Remove(fullTargetPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Remove: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package articles
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"portal/business"
+	"portal/services/PTFilesystem"
+	"portal/services/PTHash"
+
+	"github.com/pnnh/neutron/helpers"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfig 同步目录下的.polaris/repo.yml，字段都可以省略，不支持其它字段
+//
+//	REPOID: "0b3f0f5e-3c1a-4b8e-9a51-7c2d6e8f9a10"
+//	CHANNEL: "..."
+//	LANG: "zh"
+//	OWNER: "..."
+//	UIDPATH: "relative"
+type RepoConfig struct {
+	RepoId  string `yaml:"REPOID"`  // 计算文件uid时使用，同一目录每次同步的uid保持一致
+	Channel string `yaml:"CHANNEL"` // 文章的默认频道uid，同步源配置了channel时以同步源为准
+	Lang    string `yaml:"LANG"`    // 文章的默认语言，frontmatter中的lang优先
+	Owner   string `yaml:"OWNER"`   // 文件的所有者uid，同步源配置了owner时以同步源为准
+	UidPath string `yaml:"UIDPATH"` // 计算文件uid使用的路径，见uidPathMode
+}
+
+// 计算文件uid时使用文件的绝对路径或相对于同步目录的路径
+const (
+	UidPathAbsolute = "absolute"
+	UidPathRelative = "relative"
+)
+
+func repoConfigPath(rootPath string) string {
+	return filepath.Join(rootPath, ".polaris", "repo.yml")
+}
+
+// 读取并检查repo.yml，文件不存在时返回空配置
+func readRepoConfig(rootPath string) (*RepoConfig, error) {
+	repoConfig := &RepoConfig{}
+	repoFilePath := repoConfigPath(rootPath)
+	if _, err := os.Stat(repoFilePath); os.IsNotExist(err) {
+		return repoConfig, nil
+	}
+	repoFileString, err := PTFilesystem.PTReadFileAsString(repoFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取repo.yml文件失败: %w", err)
+	}
+	decoder := yaml.NewDecoder(strings.NewReader(repoFileString))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(repoConfig); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("解析repo.yml出错: %w", err)
+	}
+	if err := repoConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("repo.yml配置错误 %s: %w", repoFilePath, err)
+	}
+	return repoConfig, nil
+}
+
+// Validate 检查各字段的格式，uid统一转为小写
+func (c *RepoConfig) Validate() error {
+	c.RepoId = strings.ToLower(strings.TrimSpace(c.RepoId))
+	c.Channel = strings.ToLower(strings.TrimSpace(c.Channel))
+	c.Owner = strings.ToLower(strings.TrimSpace(c.Owner))
+	c.Lang = strings.TrimSpace(c.Lang)
+	c.UidPath = strings.ToLower(strings.TrimSpace(c.UidPath))
+	if c.RepoId != "" && !helpers.IsUuid(c.RepoId) {
+		return fmt.Errorf("REPOID不是有效的uid: %s", c.RepoId)
+	}
+	if c.Channel != "" && !helpers.IsUuid(c.Channel) {
+		return fmt.Errorf("CHANNEL不是有效的uid: %s", c.Channel)
+	}
+	if c.Owner != "" && !helpers.IsUuid(c.Owner) {
+		return fmt.Errorf("OWNER不是有效的uid: %s", c.Owner)
+	}
+	if c.Lang != "" && !business.IsSupportedLanguage(c.Lang) {
+		return fmt.Errorf("不支持的LANG: %s", c.Lang)
+	}
+	if c.UidPath != "" && c.UidPath != UidPathAbsolute && c.UidPath != UidPathRelative {
+		return fmt.Errorf("不支持的UIDPATH: %s", c.UidPath)
+	}
+	return nil
+}
+
+// 没有配置REPOID时，git仓库由第一个提交计算出固定的id，否则生成新的id，generated为true时需要写回repo.yml
+func resolveRepoId(repoConfig *RepoConfig, git *gitState) (repoId string, generated bool, err error) {
+	if repoConfig.RepoId != "" {
+		return repoConfig.RepoId, false, nil
+	}
+	if git != nil && git.info.FirstCommitId != "" {
+		md5Val, err := PTHash.PTCalculateMD5String("git-first-commit-" + git.info.FirstCommitId)
+		if err != nil {
+			return "", false, fmt.Errorf("计算仓库id失败: %w", err)
+		}
+		repoId, err = PTHash.PTMd5ToUuid(md5Val)
+		if err != nil {
+			return "", false, fmt.Errorf("MD5转换为UUID失败: %w", err)
+		}
+		return repoId, false, nil
+	}
+	return helpers.MustUuid(), true, nil
+}
+
+// 没有配置UIDPATH时，已经配置了REPOID的仓库之前按绝对路径计算uid，继续使用绝对路径保持已同步文件的uid不变，
+// 其它仓库之前每次同步都生成新的REPOID，没有需要保持的uid，使用相对路径
+func uidPathMode(repoConfig *RepoConfig) string {
+	if repoConfig.UidPath != "" {
+		return repoConfig.UidPath
+	}
+	if repoConfig.RepoId != "" {
+		return UidPathAbsolute
+	}
+	return UidPathRelative
+}
+
+// 把生成的REPOID追加到repo.yml，保留文件中已有的内容和注释，uidPath不为空时一并写入UIDPATH，
+// 之后按已配置REPOID的仓库读取时仍使用相同的路径计算uid
+func writeRepoId(rootPath, repoId, uidPath string) (err error) {
+	repoFilePath := repoConfigPath(rootPath)
+	if err := os.MkdirAll(filepath.Dir(repoFilePath), 0755); err != nil {
+		return fmt.Errorf("MkdirAll: %w", err)
+	}
+	content, err := os.ReadFile(repoFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ReadFile: %w", err)
+	}
+	line := fmt.Sprintf("REPOID: \"%s\"\n", repoId)
+	if uidPath != "" {
+		line += fmt.Sprintf("UIDPATH: \"%s\"\n", uidPath)
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		line = "\n" + line
+	}
+	repoFile, err := os.OpenFile(repoFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("OpenFile: %w", err)
+	}
+	defer func() {
+		if closeErr := repoFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("Close: %w", closeErr)
+		}
+	}()
+	if _, err = repoFile.WriteString(line); err != nil {
+		return fmt.Errorf("WriteString: %w", err)
+	}
+	return nil
+}
+
+// 同步开始前保存生成的REPOID，保存失败时不同步，避免下次同步得到不同的uid产生重复的文件
+func (w *ArticleWorker) saveRepoId() error {
+	if !w.repoIdGenerated {
+		return nil
+	}
+	uidPath := ""
+	if !w.uidPathConfigured {
+		uidPath = w.uidPath
+	}
+	if err := writeRepoId(w.rootPath, w.repoId, uidPath); err != nil {
+		return fmt.Errorf("写入%s失败，可以手动配置REPOID: %w", repoConfigPath(w.rootPath), err)
+	}
+	logrus.Infoln("已生成REPOID并写入repo.yml", w.rootPath, w.repoId)
+	w.repoIdGenerated = false
+	return nil
+}
@@ -0,0 +1,97 @@
+package articles
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"portal/services/githelper"
+
+	"github.com/pnnh/neutron/helpers"
+	"github.com/pnnh/neutron/services/filesystem"
+)
+
+// SyncSource 一个同步源，同步的文件属于Owner账号，放在Parent目录下
+type SyncSource struct {
+	Name     string   `yaml:"name"`
+	Url      string   `yaml:"url"`     // 源目录，如 file://home/blog
+	Repo     string   `yaml:"repo"`    // 远程git仓库地址，配置后克隆到缓存目录中同步，不能和url同时配置
+	Tag      string   `yaml:"tag"`     // 检出远程仓库的标签，不能和branch同时配置
+	Owner    string   `yaml:"owner"`   // 所有者账号uid
+	Parent   string   `yaml:"parent"`  // 目标父目录uid
+	Channel  string   `yaml:"channel"` // 文章所属频道uid，可以为空
+	Branch   string   `yaml:"branch"`  // 源目录是git仓库时要求的分支，为空时不检查；远程仓库检出该分支，为空时检出默认分支
+	Ignore   []string `yaml:"ignore"`  // 额外忽略的路径，支持通配符
+	RootPath string   `yaml:"-"`       // 解析后的源目录
+
+	ownerDefaulted bool // 没有配置owner，可以使用repo.yml中的OWNER
+}
+
+// Validate 检查配置并补充默认值
+func (s *SyncSource) Validate() error {
+	if (s.Url == "") == (s.Repo == "") {
+		return fmt.Errorf("同步源 %s 需要配置url或repo其中一个", s.Name)
+	}
+	if s.Tag != "" && (s.Repo == "" || s.Branch != "") {
+		return fmt.Errorf("同步源 %s 的tag只能用于repo且不能和branch同时配置", s.Name)
+	}
+	if s.Name == "" {
+		// 名称会出现在日志和同步计划中，不能带有仓库地址中的凭据
+		s.Name = githelper.RedactUrl(s.Url + s.Repo)
+	}
+	if s.Owner == "" {
+		s.Owner = SyncerArticleOwner
+		s.ownerDefaulted = true
+	}
+	if s.Parent == "" {
+		s.Parent = SyncParentUid
+	}
+	if !helpers.IsUuid(s.Owner) || !helpers.IsUuid(s.Parent) {
+		return fmt.Errorf("同步源 %s 的owner或parent不是有效的uid", s.Name)
+	}
+	if s.Channel != "" && !helpers.IsUuid(s.Channel) {
+		return fmt.Errorf("同步源 %s 的channel不是有效的uid", s.Name)
+	}
+	for _, pattern := range s.Ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("同步源 %s 的ignore格式错误 %s: %w", s.Name, pattern, err)
+		}
+	}
+	// 远程仓库的目录由缓存目录决定
+	if s.Repo != "" {
+		return nil
+	}
+	rootPath, err := filesystem.ResolvePath(s.Url)
+	if err != nil {
+		return fmt.Errorf("同步源 %s 解析路径失败: %w", s.Name, err)
+	}
+	s.RootPath = filepath.Clean(rootPath)
+	return nil
+}
+
+// IsIgnored 路径是否匹配忽略规则，不含/的规则匹配文件名，含/的规则匹配相对于源目录的路径
+func (s *SyncSource) IsIgnored(fullPath string) bool {
+	if filesystem.IsIgnoredPath(fullPath) {
+		return true
+	}
+	if len(s.Ignore) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(s.RootPath, fullPath)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range s.Ignore {
+		target := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			target = rel
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
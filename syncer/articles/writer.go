@@ -0,0 +1,218 @@
+package articles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"portal/business"
+	"portal/cloud/files"
+	"portal/services"
+	"portal/services/confighelper"
+
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/helpers/jsonmap"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	WriterPostgres = "postgres"
+	WriterHttp     = "http"
+)
+
+// FileWriter 写入同步的文件记录，写入结果通过done回调通知
+// 实现可以先缓存再批量写入，Flush之后全部回调都已执行
+type FileWriter interface {
+	Write(ctx context.Context, row *jsonmap.JsonMap, done func(err error))
+	Flush(ctx context.Context)
+}
+
+// 按SYNC_WRITER选择写入方式，默认直接写数据库
+func newFileWriter() (FileWriter, error) {
+	writer, _ := config.GetConfigurationString("SYNC_WRITER")
+	switch strings.ToLower(strings.TrimSpace(writer)) {
+	case "", WriterPostgres:
+		return &pgFileWriter{batchSize: max(confighelper.GetInt("SYNC_WRITE_BATCH_SIZE", 100), 1)}, nil
+	case WriterHttp:
+		return newHttpFileWriter()
+	default:
+		return nil, fmt.Errorf("SYNC_WRITER 只能是 %s 或 %s: %s", WriterPostgres, WriterHttp, writer)
+	}
+}
+
+// 转换为接口收到的请求内容，两种写入方式对数据的处理保持一致
+func toRequestMap(row *jsonmap.JsonMap) (*jsonmap.JsonMap, error) {
+	data, err := services.PTMarshalJsonMap(row)
+	if err != nil {
+		return nil, err
+	}
+	requestMap := jsonmap.NewJsonMap()
+	if err := json.Unmarshal(data, requestMap.InnerMapPtr()); err != nil {
+		return nil, fmt.Errorf("toRequestMap: %w", err)
+	}
+	return requestMap, nil
+}
+
+type pendingFile struct {
+	upsert *files.FileUpsert
+	done   func(err error)
+}
+
+// 直接写数据库，每batchSize条记录在一个事务中写入
+type pgFileWriter struct {
+	batchSize int
+	pending   []*pendingFile
+}
+
+func (w *pgFileWriter) Write(ctx context.Context, row *jsonmap.JsonMap, done func(err error)) {
+	requestMap, err := toRequestMap(row)
+	if err != nil {
+		done(err)
+		return
+	}
+	uid := row.GetString("uid")
+	parentPath := strings.TrimSuffix(row.GetString("path"), "."+uid)
+	dataRow, isArticle := files.NewSyncFileDataRow(uid, row.GetString("owner"), parentPath, requestMap)
+	if dataRow.Err != nil {
+		done(dataRow.Err)
+		return
+	}
+	w.pending = append(w.pending, &pendingFile{
+		upsert: &files.FileUpsert{Row: dataRow, IsArticle: isArticle},
+		done:   done,
+	})
+	if len(w.pending) >= w.batchSize {
+		w.Flush(ctx)
+	}
+}
+
+// 整批写入失败时逐条重试，避免一条出错的记录导致整批失败
+func (w *pgFileWriter) Flush(ctx context.Context) {
+	pending := w.pending
+	w.pending = nil
+	if len(pending) == 0 {
+		return
+	}
+	upserts := make([]*files.FileUpsert, 0, len(pending))
+	for _, item := range pending {
+		upserts = append(upserts, item.upsert)
+	}
+	err := files.PGUpsertFiles(upserts)
+	if err == nil || len(pending) == 1 {
+		for _, item := range pending {
+			item.done(err)
+		}
+		return
+	}
+	logrus.Warnln("批量写入文件记录失败，逐条重试", len(pending), err)
+	for _, item := range pending {
+		item.done(files.PGUpsertFiles([]*files.FileUpsert{item.upsert}))
+	}
+}
+
+// 通过Portal的同步接口写入，使用SYNC_PORTAL_TOKEN对应的账号，该账号需要配置在SYNC_ACCOUNTS中，文件归属于该账号
+type httpFileWriter struct {
+	client  *http.Client
+	baseUrl string
+	token   string
+	retries int
+}
+
+func newHttpFileWriter() (*httpFileWriter, error) {
+	portalUrl, ok := config.GetConfigurationString("INTERNAL_PORTAL_URL")
+	if !ok || portalUrl == "" {
+		return nil, fmt.Errorf("INTERNAL_PORTAL_URL 未配置")
+	}
+	token, ok := config.GetConfigurationString("SYNC_PORTAL_TOKEN")
+	if !ok || token == "" {
+		return nil, fmt.Errorf("SYNC_PORTAL_TOKEN 未配置")
+	}
+	// 接口注册在/portal下，地址中没有时补上
+	baseUrl := strings.TrimSuffix(portalUrl, "/")
+	if !strings.HasSuffix(baseUrl, "/portal") {
+		baseUrl += "/portal"
+	}
+	return &httpFileWriter{
+		client:  &http.Client{Timeout: confighelper.GetDuration("SYNC_HTTP_TIMEOUT", 30*time.Second)},
+		baseUrl: baseUrl,
+		token:   token,
+		retries: max(confighelper.GetInt("SYNC_HTTP_RETRIES", 3), 0),
+	}, nil
+}
+
+func (w *httpFileWriter) Write(ctx context.Context, row *jsonmap.JsonMap, done func(err error)) {
+	data, err := services.PTMarshalJsonMap(row)
+	if err != nil {
+		done(fmt.Errorf("序列化数据失败: %w", err))
+		return
+	}
+	postUrl := fmt.Sprintf("%s/cloud/files/%s/sync", w.baseUrl, url.PathEscape(row.GetString("uid")))
+	done(w.post(ctx, postUrl, data))
+}
+
+func (w *httpFileWriter) Flush(ctx context.Context) {}
+
+// 网络错误、5xx和429时按1s、2s、4s...的间隔重试
+func (w *httpFileWriter) post(ctx context.Context, postUrl string, data []byte) error {
+	for attempt := 0; ; attempt++ {
+		retry, err := w.postOnce(ctx, postUrl, data)
+		if err == nil || !retry || attempt >= w.retries {
+			return err
+		}
+		delay := time.Second << attempt
+		logrus.Warnln("写入文件记录失败，稍后重试", postUrl, delay, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+type portalResult struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (w *httpFileWriter) postOnce(ctx context.Context, postUrl string, data []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, postUrl, bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("创建HTTP请求失败: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.AddCookie(&http.Cookie{Name: business.AuthCookieName, Value: w.token})
+	response, err := w.client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("请求失败: %w", err)
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			logrus.Errorf("关闭HTTP响应体失败: %v", err)
+		}
+	}()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return true, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if response.StatusCode >= http.StatusInternalServerError || response.StatusCode == http.StatusTooManyRequests {
+		return true, fmt.Errorf("HTTP状态码: %d", response.StatusCode)
+	}
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("HTTP状态码: %d", response.StatusCode)
+	}
+	// 接口出错时HTTP状态码仍是200，需要检查返回的code
+	result := &portalResult{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return false, fmt.Errorf("解析响应失败: %w", err)
+	}
+	if result.Code != 0 {
+		return false, fmt.Errorf("写入失败，code: %d, %s", result.Code, result.Message)
+	}
+	return false, nil
+}
@@ -1,23 +1,26 @@
 package syncer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"portal/services/confighelper"
 	"portal/syncer/articles"
 
-	"github.com/pnnh/neutron/services/filesystem"
-
 	"github.com/pnnh/neutron/config"
 	"github.com/pnnh/neutron/services/datastore"
 
 	"github.com/sirupsen/logrus"
 )
 
-// 该程序用于定时同步文章和仓库
-// 考虑到简化实现，目前仅能够一次性执行，无法作为服务运行
-func SyncerMain(configFlag string) {
+// 该程序用于同步文章和仓库，默认执行一次后退出，定时同步可以通过scheduler的syncer.run任务执行
+// SYNC_WATCH为true时作为服务运行，监听目录变化持续同步
+// ctx取消后停止遍历目录，等待已提交的文件复制完成后退出
+// dryRun为true时只输出同步计划，planPath为计划文件路径，为空时输出到标准输出
+func SyncerMain(ctx context.Context, configFlag string, dryRun bool, planPath string) {
 	logrus.Println("Hello, Syncer!")
 
 	err := config.InitAppConfig(configFlag, "huable", "polaris", config.GetEnvName(), "syncer")
@@ -34,48 +37,102 @@ func SyncerMain(configFlag string) {
 		logrus.Fatalln("datastore: ", err)
 	}
 
-	var wg = &sync.WaitGroup{}
-	nowTime := time.Now()
-	syncno := fmt.Sprintf("SYN%s", nowTime.Format("200601021504"))
-	// 文件同步Worker
-	repoWorker, err := articles.NewRepoWorker(wg, syncno)
+	if dryRun {
+		err = RunDryRun(ctx, planPath)
+	} else if confighelper.GetBool("SYNC_WATCH", false) {
+		err = SyncWatchForever(ctx)
+	} else {
+		err = RunSync(ctx)
+	}
 	if err != nil {
-		logrus.Errorln("初始化RepoWorker失败", err)
-		return
+		logrus.Fatalln("同步出错", err)
 	}
+}
 
-	wg.Add(1)
-	go repoWorker.StartWork()
+// 同步批次号，精确到分钟
+func newSyncno() string {
+	return fmt.Sprintf("SYN%s", time.Now().Format("200601021504"))
+}
 
-	sourceUrl, ok := config.GetConfiguration("SOURCE_URL")
-	if !ok || sourceUrl == nil {
-		logrus.Fatalln("SOURCE_URL 未配置")
+// RunSync 对每个同步源执行一次完整的目录同步，依赖已经初始化的配置和datastore
+func RunSync(ctx context.Context) error {
+	sources, err := loadSources()
+	if err != nil {
+		return err
 	}
-	sourceDir, err := filesystem.ResolvePath(sourceUrl.(string))
+	syncno := newSyncno()
+	useGitDiff := confighelper.GetBool("SYNC_GIT_DIFF", true)
+	return runSources(ctx, sources, func(ctx context.Context, source *articles.SyncSource) error {
+		return runSync(ctx, source, syncno, nil, useGitDiff)
+	})
+}
 
+// paths为nil时同步整个目录，否则只同步指定的文件或目录
+// useGitDiff为true时，同步整个目录前先尝试只同步git仓库中上次同步以来变化的文件
+func runSync(ctx context.Context, source *articles.SyncSource, syncno string, paths []string, useGitDiff bool) error {
+	// 全量同步前拉取远程仓库
+	if paths == nil {
+		if err := checkoutSource(ctx, source); err != nil {
+			return fmt.Errorf("检出远程仓库出错: %w", err)
+		}
+	}
+	var wg = &sync.WaitGroup{}
+	// 文件同步Worker
+	repoWorker, err := articles.NewRepoWorker(wg, syncno)
 	if err != nil {
-		logrus.Fatalln("解析路径失败", err)
-		return
+		return fmt.Errorf("初始化RepoWorker失败: %w", err)
 	}
 
 	wg.Add(1)
-	go SyncDirectoryForever(repoWorker, sourceDir, wg, syncno)
+	go repoWorker.StartWork()
 
+	if paths == nil {
+		wg.Add(1)
+		err = SyncDirectoryForever(ctx, repoWorker, source, wg, syncno, useGitDiff)
+	} else {
+		var articleWorker *articles.ArticleWorker
+		articleWorker, err = articles.NewArticleWorker(repoWorker, source, syncno)
+		if err == nil {
+			err = articleWorker.SyncPaths(ctx, paths)
+		}
+		// 目录遍历结束后不会再有新的复制任务
+		repoWorker.Close()
+	}
 	wg.Wait()
+	// 有文件复制失败时按同步出错处理，一次性同步的进程以非零状态退出
+	if failed := repoWorker.Stats().Failed.Load(); failed > 0 {
+		err = errors.Join(err, fmt.Errorf("%d个文件复制失败", failed))
+	}
+	return err
 }
 
-func SyncDirectoryForever(repoWorker *articles.RepoWorker, dirPath string, wg *sync.WaitGroup, syncno string) {
+func SyncDirectoryForever(ctx context.Context, repoWorker *articles.RepoWorker, source *articles.SyncSource,
+	wg *sync.WaitGroup, syncno string, useGitDiff bool) error {
+	dirPath := source.RootPath
 	logrus.Println("开始定时同步目录:", dirPath)
 	defer func() {
 		logrus.Println("停止同步目录:", dirPath)
+		// 目录遍历结束后不会再有新的复制任务
+		repoWorker.Close()
 		wg.Done()
 	}()
 	logrus.Infoln("开始一次目录同步:", dirPath)
 	// 文章同步Worker
-	articleWorker, err := articles.NewArticleWorker(repoWorker, dirPath, syncno)
+	articleWorker, err := articles.NewArticleWorker(repoWorker, source, syncno)
 	if err != nil {
-		logrus.Errorln("初始化ArticleWorker失败", err)
-		return
+		return fmt.Errorf("初始化ArticleWorker失败: %w", err)
+	}
+	if useGitDiff {
+		synced, err := articleWorker.SyncChanges(ctx)
+		if err != nil {
+			return fmt.Errorf("同步仓库变化的文件出错: %w", err)
+		}
+		if synced {
+			return nil
+		}
+	}
+	if err := articleWorker.StartWork(ctx); err != nil {
+		return fmt.Errorf("同步目录出错: %w", err)
 	}
-	articleWorker.StartWork()
+	return nil
 }
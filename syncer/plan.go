@@ -0,0 +1,64 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"portal/syncer/articles"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RunDryRun 依次生成每个同步源的同步计划，以json格式写入planPath，为空时输出到标准输出
+// 不写数据库和存储，远程仓库仍会拉取到缓存目录
+func RunDryRun(ctx context.Context, planPath string) error {
+	sources, err := loadSources()
+	if err != nil {
+		return err
+	}
+	plans := make([]*articles.SyncPlan, 0, len(sources))
+	errs := make([]error, 0)
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		plan, err := planSource(ctx, source)
+		if err != nil {
+			logrus.Errorln("生成同步计划出错", source.Name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name, err))
+			continue
+		}
+		logrus.Infoln("同步计划", source.Name, "creates:", len(plan.Creates), "updates:", len(plan.Updates),
+			"moves:", len(plan.Moves), "deletes:", len(plan.Deletes))
+		plans = append(plans, plan)
+	}
+	data, err := json.MarshalIndent(map[string]any{"sources": plans}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	data = append(data, '\n')
+	if planPath == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(planPath, data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("写入同步计划出错: %w", err)
+	}
+	return errors.Join(errs...)
+}
+
+// 生成计划时不会复制文件，不需要RepoWorker
+func planSource(ctx context.Context, source *articles.SyncSource) (*articles.SyncPlan, error) {
+	if err := checkoutSource(ctx, source); err != nil {
+		return nil, fmt.Errorf("检出远程仓库出错: %w", err)
+	}
+	articleWorker, err := articles.NewArticleWorker(nil, source, newSyncno())
+	if err != nil {
+		return nil, fmt.Errorf("初始化ArticleWorker失败: %w", err)
+	}
+	return articleWorker.Plan(ctx)
+}
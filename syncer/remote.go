@@ -0,0 +1,112 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"portal/services/confighelper"
+	"portal/services/githelper"
+	"portal/syncer/articles"
+
+	"github.com/pnnh/neutron/config"
+	"github.com/pnnh/neutron/services/filesystem"
+	"github.com/sirupsen/logrus"
+)
+
+// 远程仓库的缓存目录，SYNC_CACHE_DIR未配置时使用系统缓存目录
+func resolveCacheDir() (string, error) {
+	if cacheUrl, ok := config.GetConfigurationString("SYNC_CACHE_DIR"); ok && cacheUrl != "" {
+		cacheDir, err := filesystem.ResolvePath(cacheUrl)
+		if err != nil {
+			return "", fmt.Errorf("SYNC_CACHE_DIR: %w", err)
+		}
+		return filepath.Clean(cacheDir), nil
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		userCacheDir = os.TempDir()
+	}
+	return filepath.Join(userCacheDir, "polaris", "syncer"), nil
+}
+
+var unsafeNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// 每个远程仓库一个缓存目录，目录名带上仓库地址的哈希，避免同名同步源冲突
+func remoteCachePath(cacheDir string, source *articles.SyncSource) string {
+	sum := sha256.Sum256([]byte(source.Repo))
+	return filepath.Join(cacheDir, unsafeNameRegexp.ReplaceAllString(source.Name, "_")+"-"+hex.EncodeToString(sum[:6]))
+}
+
+// 克隆或拉取远程仓库，再检出配置的分支或标签，本地仓库不处理
+func checkoutSource(ctx context.Context, source *articles.SyncSource) error {
+	if source.Repo == "" {
+		return nil
+	}
+	timeout := confighelper.GetDuration("SYNC_GIT_TIMEOUT", 10*time.Minute)
+	gitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dirPath := source.RootPath
+	if _, err := os.Stat(filepath.Join(dirPath, ".git")); os.IsNotExist(err) {
+		logrus.Infoln("克隆远程仓库", source.Name, dirPath)
+		// 上次克隆中断时可能留下不完整的目录
+		if err := os.RemoveAll(dirPath); err != nil {
+			return fmt.Errorf("RemoveAll: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(dirPath), 0755); err != nil {
+			return fmt.Errorf("MkdirAll: %w", err)
+		}
+		if err := githelper.GitClone(gitCtx, source.Repo, dirPath); err != nil {
+			return err
+		}
+	} else {
+		logrus.Infoln("拉取远程仓库", source.Name, dirPath)
+		if err := githelper.GitFetch(gitCtx, dirPath, source.Repo); err != nil {
+			return err
+		}
+	}
+
+	if source.Tag != "" {
+		return githelper.GitCheckoutTag(gitCtx, dirPath, source.Tag)
+	}
+	branch := source.Branch
+	if branch == "" {
+		defaultBranch, err := githelper.GitRemoteDefaultBranch(gitCtx, dirPath)
+		if err != nil {
+			return err
+		}
+		branch = defaultBranch
+	}
+	return githelper.GitCheckoutBranch(gitCtx, dirPath, branch)
+}
+
+// 远程仓库的缓存目录只会被同步过程修改，不需要监听，每隔SYNC_RESCAN_INTERVAL拉取并同步一次
+func pollRemoteSource(ctx context.Context, source *articles.SyncSource, runLimited func(syncno string) error) error {
+	interval := confighelper.GetDuration("SYNC_RESCAN_INTERVAL", time.Hour)
+	if interval <= 0 {
+		return fmt.Errorf("远程仓库需要配置SYNC_RESCAN_INTERVAL")
+	}
+	if err := runLimited(newSyncno()); err != nil {
+		logrus.Errorln("同步远程仓库出错", source.Name, err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	logrus.Println("开始定时拉取远程仓库:", source.Name, interval)
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Println("停止拉取远程仓库:", source.Name)
+			return nil
+		case <-ticker.C:
+			if err := runLimited(newSyncno()); err != nil {
+				logrus.Errorln("同步远程仓库出错", source.Name, err)
+			}
+		}
+	}
+}
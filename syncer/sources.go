@@ -0,0 +1,98 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"portal/services/confighelper"
+	"portal/syncer/articles"
+
+	"github.com/pnnh/neutron/config"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// 读取SYNC_SOURCES配置的同步源列表，支持yaml数组，也支持yaml或json格式的字符串
+// 未配置时使用SOURCE_URL作为唯一的同步源
+func loadSources() ([]*articles.SyncSource, error) {
+	var sources []*articles.SyncSource
+	value, ok := config.GetConfiguration("SYNC_SOURCES")
+	if ok && value != nil {
+		var data []byte
+		if str, isString := value.(string); isString {
+			data = []byte(str)
+		} else {
+			var err error
+			if data, err = yaml.Marshal(value); err != nil {
+				return nil, fmt.Errorf("SYNC_SOURCES: %w", err)
+			}
+		}
+		if err := yaml.Unmarshal(data, &sources); err != nil {
+			return nil, fmt.Errorf("SYNC_SOURCES: %w", err)
+		}
+	} else {
+		sourceUrl, ok := config.GetConfigurationString("SOURCE_URL")
+		if !ok || sourceUrl == "" {
+			return nil, fmt.Errorf("SYNC_SOURCES 和 SOURCE_URL 都未配置")
+		}
+		sources = append(sources, &articles.SyncSource{Url: sourceUrl})
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("SYNC_SOURCES 为空")
+	}
+
+	// 清理已删除的文件时按父目录查询，多个同步源共用父目录会互相清理
+	parents := make(map[string]string, len(sources))
+	cacheDir := ""
+	for _, source := range sources {
+		if err := source.Validate(); err != nil {
+			return nil, err
+		}
+		if source.Repo != "" {
+			if cacheDir == "" {
+				var err error
+				if cacheDir, err = resolveCacheDir(); err != nil {
+					return nil, err
+				}
+			}
+			source.RootPath = remoteCachePath(cacheDir, source)
+		}
+		if name, ok := parents[source.Parent]; ok {
+			return nil, fmt.Errorf("同步源 %s 和 %s 的parent相同", name, source.Name)
+		}
+		parents[source.Parent] = source.Name
+	}
+	return sources, nil
+}
+
+// 限制同时执行同步的同步源数量
+func newSyncLimiter() chan struct{} {
+	return make(chan struct{}, max(confighelper.GetInt("SYNC_PARALLEL", 2), 1))
+}
+
+// 并发执行各同步源，最多同时执行SYNC_PARALLEL个，一个同步源出错不影响其它同步源
+func runSources(ctx context.Context, sources []*articles.SyncSource,
+	run func(ctx context.Context, source *articles.SyncSource) error) error {
+	semaphore := newSyncLimiter()
+	errs := make([]error, len(sources))
+	wg := &sync.WaitGroup{}
+	for index, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if ctx.Err() != nil {
+				return
+			}
+			if err := run(ctx, source); err != nil {
+				logrus.Errorln("同步源出错", source.Name, err)
+				errs[index] = fmt.Errorf("%s: %w", source.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
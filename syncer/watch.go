@@ -0,0 +1,195 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"portal/services/confighelper"
+	"portal/syncer/articles"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// SyncWatchForever 每个同步源各自监听目录，同时执行同步的同步源数量受SYNC_PARALLEL限制
+// 一个同步源出错退出不影响其它同步源
+func SyncWatchForever(ctx context.Context) error {
+	sources, err := loadSources()
+	if err != nil {
+		return err
+	}
+	limiter := newSyncLimiter()
+	errs := make([]error, len(sources))
+	wg := &sync.WaitGroup{}
+	for index, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := watchSource(ctx, source, limiter); err != nil {
+				logrus.Errorln("同步源监听出错", source.Name, err)
+				errs[index] = fmt.Errorf("%s: %w", source.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// 先全量同步一次，之后监听目录变化，变化停止SYNC_WATCH_DEBOUNCE后只同步变化的路径
+// 每隔SYNC_RESCAN_INTERVAL全量同步一次，补上监听遗漏的变化和删除，此时不按git提交增量同步
+func watchSource(ctx context.Context, source *articles.SyncSource, limiter chan struct{}) error {
+	sourceDir := source.RootPath
+	runLimited := func(syncno string, paths []string, useGitDiff bool) error {
+		limiter <- struct{}{}
+		defer func() { <-limiter }()
+		return runSync(ctx, source, syncno, paths, useGitDiff)
+	}
+	if source.Repo != "" {
+		return pollRemoteSource(ctx, source, func(syncno string) error {
+			return runLimited(syncno, nil, confighelper.GetBool("SYNC_GIT_DIFF", true))
+		})
+	}
+	debounce := confighelper.GetDuration("SYNC_WATCH_DEBOUNCE", 2*time.Second)
+	rescanInterval := confighelper.GetDuration("SYNC_RESCAN_INTERVAL", time.Hour)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("fsnotify.NewWatcher: %w", err)
+	}
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			logrus.Warnln("关闭目录监听出错:", err)
+		}
+	}()
+	// 先监听再全量同步，避免遗漏同步期间的变化
+	if err = watchTree(watcher, source, sourceDir); err != nil {
+		return err
+	}
+
+	syncno := newSyncno()
+	if err = runLimited(syncno, nil, confighelper.GetBool("SYNC_GIT_DIFF", true)); err != nil {
+		logrus.Errorln("全量同步出错", err)
+	}
+
+	debounceTimer := time.NewTimer(debounce)
+	debounceTimer.Stop()
+	defer debounceTimer.Stop()
+	var rescanChan <-chan time.Time
+	if rescanInterval > 0 {
+		rescanTicker := time.NewTicker(rescanInterval)
+		defer rescanTicker.Stop()
+		rescanChan = rescanTicker.C
+	}
+
+	logrus.Println("开始监听目录变化:", sourceDir, debounce, rescanInterval)
+	pending := make(map[string]struct{})
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Println("停止监听目录:", sourceDir)
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return fmt.Errorf("目录监听已关闭")
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) &&
+				!event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			// 新建的目录需要单独添加监听，目录中已有的文件随目录一起同步
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, source, event.Name); err != nil {
+						logrus.Warnln("监听新目录出错", event.Name, err)
+					}
+				}
+			}
+			pending[event.Name] = struct{}{}
+			debounceTimer.Reset(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return fmt.Errorf("目录监听已关闭")
+			}
+			logrus.Errorln("目录监听出错", err)
+			// 事件溢出时无法知道哪些文件发生了变化，改为全量同步
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				syncno = newSyncno()
+				clear(pending)
+				if err := runLimited(syncno, nil, false); err != nil {
+					logrus.Errorln("全量同步出错", err)
+				}
+			}
+		case <-debounceTimer.C:
+			paths := compactPaths(pending)
+			clear(pending)
+			logrus.Infoln("同步变化的路径", len(paths))
+			// 增量同步沿用上一次全量同步的批次号
+			if err := runLimited(syncno, paths, false); err != nil {
+				logrus.Errorln("增量同步出错", err)
+			}
+		case <-rescanChan:
+			syncno = newSyncno()
+			clear(pending)
+			if err := watchTree(watcher, source, sourceDir); err != nil {
+				logrus.Warnln("重新监听目录出错", err)
+			}
+			if err := runLimited(syncno, nil, false); err != nil {
+				logrus.Errorln("全量同步出错", err)
+			}
+		}
+	}
+}
+
+// 监听目录及其下的所有子目录，跳过隐藏目录和忽略的目录，已经监听的目录重复添加没有影响
+func watchTree(watcher *fsnotify.Watcher, source *articles.SyncSource, root string) error {
+	return filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			// 遍历过程中被删除的目录直接跳过
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != root && (strings.HasPrefix(entry.Name(), ".") || source.IsIgnored(path)) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("watcher.Add %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// 去掉已经被上级目录包含的路径，按路径排序保证先同步上级目录
+func compactPaths(pending map[string]struct{}) []string {
+	paths := make([]string, 0, len(pending))
+	for path := range pending {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		contained := false
+		for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if _, ok := pending[dir]; ok {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			result = append(result, path)
+		}
+	}
+	return result
+}
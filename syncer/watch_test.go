@@ -0,0 +1,35 @@
+package syncer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompactPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending []string
+		want    []string
+	}{
+		{"empty", nil, []string{}},
+		{"single", []string{"/repo/a.md"}, []string{"/repo/a.md"}},
+		{"sorted", []string{"/repo/b.md", "/repo/a.md"}, []string{"/repo/a.md", "/repo/b.md"}},
+		{"parent contains child", []string{"/repo/posts/a.md", "/repo/posts"}, []string{"/repo/posts"}},
+		{"ancestor contains grandchild", []string{"/repo/posts/2024/01/a.md", "/repo/posts"}, []string{"/repo/posts"}},
+		{"similar prefix is not parent", []string{"/repo/post", "/repo/posts/a.md"},
+			[]string{"/repo/post", "/repo/posts/a.md"}},
+		{"siblings", []string{"/repo/x/a.md", "/repo/y", "/repo/y/b.md", "/repo/x/c.md"},
+			[]string{"/repo/x/a.md", "/repo/x/c.md", "/repo/y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending := make(map[string]struct{})
+			for _, path := range tt.pending {
+				pending[path] = struct{}{}
+			}
+			if got := compactPaths(pending); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compactPaths(%v) = %v, want %v", tt.pending, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"portal/business/notifications"
+	"portal/services/confighelper"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 定时提交通知摘要任务，NOTIFICATION_EMAIL_DIGEST为true时启用
+func runNotificationDigest(ctx context.Context) {
+	interval := confighelper.GetDuration("NOTIFICATION_DIGEST_INTERVAL", time.Hour)
+	logrus.Println("通知摘要邮件已启用，间隔", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := notifications.EnqueueDigest(ctx); err != nil {
+			logrus.Errorln("提交通知摘要任务失败:", err)
+		}
+	}
+}
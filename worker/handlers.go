@@ -0,0 +1,32 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"portal/business/notifications"
+	"portal/services/jobs"
+	"portal/services/mailer"
+
+	"github.com/pnnh/neutron/config"
+)
+
+// 注册worker进程支持的任务
+func registerJobHandlers() {
+	jobs.Register(jobs.JobMailSend, handleMailSend)
+	jobs.Register(notifications.JobNotificationDigest, func(ctx context.Context, job *jobs.JobModel) error {
+		return notifications.SendEmailDigests()
+	})
+}
+
+func handleMailSend(ctx context.Context, job *jobs.JobModel) error {
+	payload := &jobs.MailSendPayload{}
+	if err := job.Bind(payload); err != nil {
+		return err
+	}
+	mailSender, ok := config.GetConfigurationString("MAIL_SENDER")
+	if !ok || mailSender == "" {
+		return fmt.Errorf("MAIL_SENDER未配置")
+	}
+	return mailer.SendMail(mailSender, payload.Subject, payload.Body, payload.To...)
+}
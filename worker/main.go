@@ -2,39 +2,26 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"time"
+	"sync"
 
-	"portal/business/comments"
-	"portal/business/viewers"
+	"portal/services/confighelper"
+	"portal/services/jobs"
+	"portal/services/queue"
 
 	"github.com/pnnh/neutron/config"
 	"github.com/pnnh/neutron/services/datastore"
-	"github.com/pnnh/neutron/services/redisdb"
 
 	"github.com/sirupsen/logrus"
 )
 
-func WorkerMain(configFlag string) {
+// WorkerMain ctx取消后不再读取新的消息，等待执行中的任务完成后退出
+func WorkerMain(ctx context.Context, configFlag string) {
 
 	err := config.InitAppConfig(configFlag, "huable", "polaris", config.GetEnvName(), "worker")
 	if err != nil {
 		logrus.Fatalln("初始化配置失败3", err)
 	}
 
-	redisUrl, ok := config.GetConfigurationString("REDIS_URL")
-	if !ok || redisUrl == "" {
-		logrus.Fatalln("REDIS_URL not found in configuration")
-	}
-	redisClient, err := redisdb.ConnectRedis(context.Background(), redisUrl)
-	if err != nil {
-		logrus.Fatalln("redisdb.ConnectRedis error:", err)
-	}
-
-	logrus.Println("Redis初始化完成")
-
 	accountDSN, ok := config.GetConfiguration("DATABASE")
 	if !ok || accountDSN == nil {
 		logrus.Errorln("DATABASE未配置3")
@@ -45,44 +32,33 @@ func WorkerMain(configFlag string) {
 	}
 	logrus.Println("DATABASE初始化完成")
 
-	logrus.Println("Starting comment viewer worker...")
-	for {
-		contentData, err := redisdb.Consume(context.Background(), redisClient, comments.CommentViewersRedisKey)
-		if err != nil {
-			logrus.Errorln("消费数据失败:", err)
-			continue
-		}
-
-		commentViewers := make([]*viewers.MTViewerTable, 0)
-		if err := json.Unmarshal(contentData, &commentViewers); err != nil {
-			logrus.Errorln("commentViewers Unmarshal error:", err)
-			continue
-		}
-		if len(commentViewers) == 0 {
-			continue
-		}
-		if err := SaveToDatabase(commentViewers); err != nil {
-			logrus.Errorln("保存到数据库失败:", err, string(contentData))
-			continue
-		}
-
-		// 模拟生产间隔
-		time.Sleep(1 * time.Second)
-	}
-}
-
-func SaveToDatabase(commentViewers []*viewers.MTViewerTable) error {
-	// 模拟保存到数据库的操作
-
-	opErr, itemErrs := viewers.PGInsertViewer(commentViewers...)
-	if opErr != nil {
-		return fmt.Errorf("PGInsertViewer: %v", opErr)
+	// postgres队列依赖datastore，需要在数据库初始化之后打开
+	workerQueue, err := queue.Open(ctx)
+	if err != nil {
+		logrus.Fatalln("queue.Open error:", err)
 	}
-	for key, item := range itemErrs {
-		if !errors.Is(item, viewers.ErrViewerLogExists) {
-			logrus.Warnln("CommentSelectHandler", key, item)
-		}
+	logrus.Println("消息队列初始化完成", queue.Driver())
+
+	jobs.UseQueue(workerQueue)
+	registerJobHandlers()
+
+	waitGroup := &sync.WaitGroup{}
+	waitGroup.Add(2)
+	go func() {
+		defer waitGroup.Done()
+		jobs.NewRunner(workerQueue, jobs.QueueConcurrency()).Run(ctx)
+	}()
+	go func() {
+		defer waitGroup.Done()
+		newCommentViewerWorker(workerQueue).run(ctx)
+	}()
+	if confighelper.GetBool("NOTIFICATION_EMAIL_DIGEST", false) {
+		go runNotificationDigest(ctx)
 	}
+	waitGroup.Wait()
 
-	return nil
+	if err := workerQueue.Close(); err != nil {
+		logrus.Warnln("关闭消息队列出错:", err)
+	}
+	logrus.Println("worker已停止")
 }
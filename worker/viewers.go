@@ -0,0 +1,220 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"portal/business/comments"
+	"portal/business/viewers"
+	"portal/services/confighelper"
+	"portal/services/queue"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 多次写入失败或无法解析的消息移入死信队列，供人工排查
+const CommentViewersDeadKey = comments.CommentViewersRedisKey + ":dead"
+
+// 写入失败等待重试的消息，到期后移回comment:viewers
+const commentViewersRetryKey = comments.CommentViewersRedisKey + ":retry"
+
+// 读取队列出错时的等待时间，避免队列不可用时空转
+const queueErrorBackoff = 3 * time.Second
+
+// 等待重试的消息的检查间隔
+const retryPromoteInterval = time.Second
+
+type commentViewerWorker struct {
+	queue       queue.Queue
+	batchSize   int
+	batchWait   time.Duration
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+}
+
+func newCommentViewerWorker(viewerQueue queue.Queue) *commentViewerWorker {
+	return &commentViewerWorker{
+		queue:       viewerQueue,
+		batchSize:   max(confighelper.GetInt("COMMENT_VIEWER_BATCH_SIZE", 50), 1),
+		batchWait:   confighelper.GetDuration("COMMENT_VIEWER_BATCH_WAIT", 500*time.Millisecond),
+		maxAttempts: max(confighelper.GetInt("COMMENT_VIEWER_MAX_ATTEMPTS", 5), 1),
+		retryBase:   confighelper.GetDuration("COMMENT_VIEWER_RETRY_BASE", time.Second),
+		retryMax:    confighelper.GetDuration("COMMENT_VIEWER_RETRY_MAX", 5*time.Minute),
+	}
+}
+
+// 每次最多取batchSize条消息或等待batchWait，合并后在一个事务中写入
+func (w *commentViewerWorker) run(ctx context.Context) {
+	logrus.Println("Starting comment viewer worker...", w.batchSize, w.batchWait)
+	promoted := make(chan struct{})
+	go func() {
+		defer close(promoted)
+		w.promoteRetries(ctx)
+	}()
+	defer func() { <-promoted }()
+	for {
+		rawMessages, err := w.queue.PopBatch(ctx, comments.CommentViewersRedisKey, w.batchSize, w.batchWait)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorln("消费数据失败:", err)
+		}
+		if len(rawMessages) > 0 {
+			w.processBatch(ctx, rawMessages)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(queueErrorBackoff):
+			}
+		}
+	}
+}
+
+func (w *commentViewerWorker) processBatch(ctx context.Context, rawMessages [][]byte) {
+	messages := make([]*viewers.MTViewerMessage, 0, len(rawMessages))
+	for _, data := range rawMessages {
+		message, err := decodeViewerMessage(data)
+		if err != nil {
+			logrus.Errorln("commentViewers Unmarshal error, 移入死信队列:", err, string(data))
+			w.pushDead(ctx, data)
+			continue
+		}
+		if len(message.Viewers) > 0 {
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) > 0 {
+		w.saveMessages(ctx, messages)
+	}
+}
+
+// 兼容旧格式：直接序列化的浏览记录数组
+func decodeViewerMessage(data []byte) (*viewers.MTViewerMessage, error) {
+	message := &viewers.MTViewerMessage{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &message.Viewers); err != nil {
+			return nil, err
+		}
+		return message, nil
+	}
+	if err := json.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// 整批写入失败时逐条消息重新写入，把有问题的消息隔离出来单独重试
+func (w *commentViewerWorker) saveMessages(ctx context.Context, messages []*viewers.MTViewerMessage) {
+	tables := make([]*viewers.MTViewerTable, 0)
+	for _, message := range messages {
+		for _, model := range message.Viewers {
+			tables = append(tables, model.ToTable())
+		}
+	}
+
+	opErr, itemErrs := SaveToDatabase(tables)
+	if opErr != nil {
+		if len(messages) > 1 {
+			logrus.Warnln("批量保存评论浏览记录失败，逐条重试:", opErr)
+			for _, message := range messages {
+				w.saveMessages(ctx, []*viewers.MTViewerMessage{message})
+			}
+			return
+		}
+		logrus.Errorln("保存到数据库失败:", opErr)
+		w.retry(ctx, messages[0])
+		return
+	}
+
+	for _, message := range messages {
+		failed := make([]*viewers.MTViewerModel, 0)
+		for _, model := range message.Viewers {
+			if itemErr, ok := itemErrs[model.Uid]; ok {
+				logrus.Warnln("保存评论浏览记录失败", model.Uid, itemErr)
+				failed = append(failed, model)
+			}
+		}
+		if len(failed) > 0 {
+			w.retry(ctx, &viewers.MTViewerMessage{Attempts: message.Attempts, Viewers: failed})
+		}
+	}
+}
+
+// 把到期的重试消息移回comment:viewers
+func (w *commentViewerWorker) promoteRetries(ctx context.Context) {
+	ticker := time.NewTicker(retryPromoteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		messages, err := w.queue.PopDue(ctx, commentViewersRetryKey, time.Now(), 100)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorln("读取等待重试的评论浏览记录出错:", err)
+		}
+		for _, data := range messages {
+			if err := w.queue.Push(context.WithoutCancel(ctx), comments.CommentViewersRedisKey, data); err != nil {
+				logrus.Errorln("评论浏览记录重新入队失败:", err, string(data))
+			}
+		}
+	}
+}
+
+// 第n次失败后等待 retryBase * 2^(n-1)，不超过retryMax
+func (w *commentViewerWorker) backoff(attempts int) time.Duration {
+	delay := w.retryBase
+	for index := 1; index < attempts && delay < w.retryMax; index++ {
+		delay *= 2
+	}
+	return min(delay, w.retryMax)
+}
+
+// 按退避时间延迟后重新放回队列，避免数据库短暂不可用时很快用完重试次数，超过最大次数后移入死信队列
+func (w *commentViewerWorker) retry(ctx context.Context, message *viewers.MTViewerMessage) {
+	message.Attempts += 1
+	data, err := json.Marshal(message)
+	if err != nil {
+		logrus.Errorln("commentViewers Marshal error:", err)
+		return
+	}
+	if message.Attempts >= w.maxAttempts {
+		logrus.Errorln("评论浏览记录重试次数过多，移入死信队列", message.Attempts, len(message.Viewers))
+		w.pushDead(ctx, data)
+		return
+	}
+	runAt := time.Now().Add(w.backoff(message.Attempts))
+	if err = w.queue.Schedule(context.WithoutCancel(ctx), commentViewersRetryKey, runAt, data); err != nil {
+		logrus.Errorln("评论浏览记录重新入队失败:", err, string(data))
+	}
+}
+
+func (w *commentViewerWorker) pushDead(ctx context.Context, data []byte) {
+	if err := w.queue.Push(context.WithoutCancel(ctx), CommentViewersDeadKey, data); err != nil {
+		logrus.Errorln("评论浏览记录写入死信队列失败:", err, string(data))
+	}
+}
+
+// SaveToDatabase 在一个事务中写入浏览记录，24小时内的重复浏览不算错误
+func SaveToDatabase(commentViewers []*viewers.MTViewerTable) (error, map[string]error) {
+	opErr, itemErrs := viewers.PGInsertViewer(commentViewers...)
+	if opErr != nil {
+		return fmt.Errorf("PGInsertViewer: %w", opErr), nil
+	}
+	failedErrs := make(map[string]error)
+	for key, item := range itemErrs {
+		if !errors.Is(item, viewers.ErrViewerLogExists) {
+			failedErrs[key] = item
+		}
+	}
+	return nil, failedErrs
+}